@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fkmiec/goscript/util"
+)
+
+// Where an alias in the effective imports table is defined. Later sources override earlier ones.
+const (
+	aliasBuiltIn   = "built-in"  //util.ImportsMap compiled into goscript
+	aliasGenerated = "generated" //stdimports.json written by --refresh-imports
+	aliasProject   = "project"   //the helper library <project>/lib and the prelude <project>/prelude
+	aliasUser      = "user"      //imports.json written by --goget, --alias or by hand
+)
+
+type aliasEntry struct {
+	Path   string
+	Source string
+}
+
+// Build the effective imports table (see loadImportsMap) along with the source of each alias.
+func loadAliasEntries() map[string]aliasEntry {
+	entries := make(map[string]aliasEntry)
+	for alias, path := range util.ImportsMap {
+		entries[alias] = aliasEntry{path, aliasBuiltIn}
+	}
+	for alias, paths := range readGeneratedImports() {
+		entries[alias] = aliasEntry{paths[0], aliasGenerated}
+	}
+	if libPath := libImportPath(); libPath != "" {
+		entries[libAlias] = aliasEntry{libPath, aliasProject}
+	}
+	if preludePath := preludeImportPath(); preludePath != "" {
+		entries[preludeAlias] = aliasEntry{preludePath, aliasProject}
+	}
+	for alias, path := range readUserImports() {
+		entries[alias] = aliasEntry{path, aliasUser}
+	}
+	return entries
+}
+
+// Handle --alias add <alias>=<importpath> | rm <alias> | list | which <alias>.
+func manageAliases(op string, args []string) {
+	switch op {
+	case "add":
+		if len(args) != 1 || !strings.Contains(args[0], "=") {
+			check(fmt.Errorf("Usage: --alias add <alias>=<importpath>"), 2, "")
+		}
+		alias, path, _ := strings.Cut(args[0], "=")
+		addAlias(strings.TrimSpace(alias), strings.TrimSpace(path))
+	case "rm":
+		if len(args) != 1 {
+			check(fmt.Errorf("Usage: --alias rm <alias>"), 2, "")
+		}
+		removeAlias(args[0])
+	case "list":
+		listAliases()
+	case "which":
+		if len(args) != 1 {
+			check(fmt.Errorf("Usage: --alias which <alias>"), 2, "")
+		}
+		whichAlias(args[0])
+	default:
+		check(fmt.Errorf("Unknown --alias operation %q. Use add, rm, list or which.", op), 2, "")
+	}
+}
+
+func addAlias(alias, path string) {
+	if !token.IsIdentifier(alias) {
+		check(fmt.Errorf("Alias %q is not a valid Go identifier.", alias), 2, "")
+	}
+	err := validateImportPath(path)
+	check(err, 2, "")
+
+	unlock := lockProject(true)
+	defer unlock()
+	userImports := readUserImports()
+	if userImports == nil {
+		userImports = make(map[string]string)
+	}
+	if previous, found := loadAliasEntries()[alias]; found && previous.Path != path {
+		fmt.Printf("%s was %s (%s)\n", alias, previous.Path, previous.Source)
+	}
+	userImports[alias] = path
+	writeUserImports(userImports)
+	fmt.Printf("%s -> %s\n", alias, path)
+}
+
+func removeAlias(alias string) {
+	unlock := lockProject(true)
+	defer unlock()
+	userImports := readUserImports()
+	if _, found := userImports[alias]; !found {
+		entry, found := loadAliasEntries()[alias]
+		if found {
+			check(fmt.Errorf("%s is a %s alias for %s. Only aliases in imports.json can be removed. Use --alias add to override it.", alias, entry.Source, entry.Path), 2, "")
+		}
+		check(fmt.Errorf("No alias %s in imports.json.", alias), 2, "")
+	}
+	delete(userImports, alias)
+	writeUserImports(userImports)
+	if entry, found := loadAliasEntries()[alias]; found {
+		fmt.Printf("%s removed from imports.json. It now resolves to %s (%s).\n", alias, entry.Path, entry.Source)
+	} else {
+		fmt.Printf("%s removed from imports.json.\n", alias)
+	}
+}
+
+func listAliases() {
+	entries := loadAliasEntries()
+	aliases := make([]string, 0, len(entries))
+	for alias := range entries {
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ALIAS\tIMPORT PATH\tSOURCE")
+	for _, alias := range aliases {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", alias, entries[alias].Path, entries[alias].Source)
+	}
+	w.Flush()
+}
+
+func whichAlias(alias string) {
+	entry, found := loadAliasEntries()[alias]
+	if !found {
+		//Not in any table, but it may still resolve through the package index (see lookupPackageIndex)
+		pkgs := lookupPackageIndex(alias)
+		if len(pkgs) == 0 {
+			check(fmt.Errorf("No alias %s.", alias), 2, "")
+		}
+		for _, pkg := range pkgs {
+			fmt.Printf("%s (package index, %s %s)\n", pkg.ImportPath, pkg.Module, pkg.Version)
+		}
+		return
+	}
+	fmt.Printf("%s (%s)\n", entry.Path, entry.Source)
+	if paths := loadImportCandidates()[alias]; len(paths) > 1 {
+		fmt.Printf("  shared with: %s (resolved by the symbols used, see goscript.conf [imports])\n", strings.Join(slices.DeleteFunc(slices.Clone(paths), func(p string) bool { return p == entry.Path }), ", "))
+	}
+	if err := validateImportPath(entry.Path); err != nil {
+		fmt.Printf("  warning: %v\n", err)
+	}
+}
+
+// Check that an import path resolves to a package in the context of the project module
+// (the standard library, the project itself or a module required by go.mod).
+func validateImportPath(path string) error {
+	cmd := exec.Command("go", "list", "-f", "{{.Name}}", path)
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s does not resolve in the project module. Use --goget to add its module first.\n%s", path, strings.TrimSpace(string(out)))
+	}
+	if strings.TrimSpace(string(out)) == "main" {
+		return fmt.Errorf("%s is a command (package main) and can't be imported.", path)
+	}
+	return nil
+}
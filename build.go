@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The build profiles goscript knows without configuration. A [profile <name>] section in
+// goscript.conf replaces one of these or adds a new profile.
+var builtInProfiles = map[string]map[string]string{
+	"debug":   {"flags": `-gcflags="all=-N -l"`},       //No optimization or inlining, for debuggers
+	"release": {"flags": `-trimpath -ldflags="-s -w"`}, //Smaller binaries without symbol tables or local paths
+}
+
+// The profile selected with --profile. It overrides the profile recorded in a command's header.
+var buildProfile string
+
+// The go build flags and environment for a command.
+type buildSettings struct {
+	Profile string
+	Flags   []string //e.g. -tags=sqlite -race
+	Env     []string //e.g. CGO_ENABLED=0
+}
+
+// Resolve the build settings for a source file. Later settings take precedence over earlier ones:
+// the [build] section of goscript.conf, then the profile (--profile or the //goscript:profile header),
+// then the //goscript:build and //goscript:env header directives of the source.
+//
+// Example header:
+//
+//	//goscript:profile release
+//	//goscript:build -tags=netgo -ldflags="-X main.version=1.0"
+//	//goscript:env CGO_ENABLED=0 GOAMD64=v3
+func readBuildSettings(src []byte) (buildSettings, error) {
+	var settings buildSettings
+	config := readProjectConfig()
+	directives := readHeaderDirectives(string(src))
+
+	add := func(origin, flags, env string) error {
+		flagList, err := splitArgs(flags)
+		if err != nil {
+			return fmt.Errorf("Invalid build flags in %s: %v", origin, err)
+		}
+		for _, flag := range flagList {
+			if !strings.HasPrefix(flag, "-") {
+				return fmt.Errorf("Invalid build flag %q in %s. Flags start with -", flag, origin)
+			}
+		}
+		envList, err := splitArgs(env)
+		if err != nil {
+			return fmt.Errorf("Invalid build env in %s: %v", origin, err)
+		}
+		for _, kv := range envList {
+			if !strings.Contains(kv, "=") {
+				return fmt.Errorf("Invalid build env %q in %s. Use NAME=value", kv, origin)
+			}
+		}
+		settings.Flags = append(settings.Flags, flagList...)
+		settings.Env = append(settings.Env, envList...)
+		return nil
+	}
+
+	err := add("the [build] section of goscript.conf", config["build"]["flags"], config["build"]["env"])
+	if err != nil {
+		return settings, err
+	}
+
+	settings.Profile = buildProfile
+	if settings.Profile == "" {
+		settings.Profile = readProfileHeader(string(src))
+	}
+	if settings.Profile != "" {
+		profile, found := config["profile "+settings.Profile]
+		if !found {
+			profile, found = builtInProfiles[settings.Profile]
+		}
+		if !found {
+			return settings, fmt.Errorf("Unknown build profile %q. Available profiles: %s", settings.Profile, strings.Join(listProfiles(config), ", "))
+		}
+		err = add("profile "+settings.Profile, profile["flags"], profile["env"])
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	err = add("the //goscript:build and //goscript:env header", strings.Join(directives["build"], " "), strings.Join(directives["env"], " "))
+	return settings, err
+}
+
+// The built-in profiles and those defined in goscript.conf, sorted.
+func listProfiles(config map[string]map[string]string) []string {
+	var names []string
+	for name := range builtInProfiles {
+		names = append(names, name)
+	}
+	for section := range config {
+		if name, found := strings.CutPrefix(section, "profile "); found && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Return the //goscript:<key> <value> directives in the header of a source file (the lines before the
+// package clause), as key -> values in order of appearance.
+func readHeaderDirectives(src string) map[string][]string {
+	directives := make(map[string][]string)
+	for _, line := range strings.Split(src, "\n") {
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if directive, found := strings.CutPrefix(strings.TrimSpace(line), "//goscript:"); found {
+			key, value, _ := strings.Cut(directive, " ")
+			directives[key] = append(directives[key], strings.TrimSpace(value))
+		}
+	}
+	return directives
+}
+
+// Set the //goscript:<key> directive in the header of a source file, replacing an existing one,
+// so settings given on the command line are remembered by the command (e.g. by --recompile).
+func setHeaderDirective(src *bytes.Buffer, key, value string) *bytes.Buffer {
+	directive := "//goscript:" + key + " " + value
+	lines := strings.SplitAfter(src.String(), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "//goscript:"+key+" ") {
+			lines[i] = directive + "\n"
+			return bytes.NewBufferString(strings.Join(lines, ""))
+		}
+	}
+	return bytes.NewBufferString(directive + "\n" + src.String())
+}
+
+// The profile saved in the header of a source file, or "" if it has none.
+func readProfileHeader(src string) string {
+	if profiles := readHeaderDirectives(src)["profile"]; len(profiles) > 0 {
+		return profiles[0]
+	}
+	return ""
+}
+
+// Save the --profile in the header of a named command's source (see setHeaderDirective), unless it is
+// already there, so the command keeps the profile when it is rebuilt later (--recompile --save-profile).
+// Call it once the binary is built with the profile. The binary's time is then updated, so the changed
+// header doesn't make the command look stale.
+func saveProfileHeader(srcFilename, binFilename string) error {
+	if buildProfile == "" {
+		return nil
+	}
+	src, err := os.ReadFile(srcFilename)
+	if err != nil || readProfileHeader(string(src)) == buildProfile {
+		return err
+	}
+	err = writeFileAtomic(srcFilename, setHeaderDirective(bytes.NewBuffer(src), "profile", buildProfile).Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(binFilename, now, now)
+}
+
+// Split a command line into arguments at spaces, honoring single and double quotes
+// (e.g. -tags=x -ldflags="-s -w" gives [-tags=x -ldflags=-s -w]).
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %s", quote, s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default limits of the build cache. Override them in the [cache] section of goscript.conf.
+const (
+	defaultCacheMaxSize = "512M"
+	defaultCacheMaxAge  = "30d"
+)
+
+// The directory holding cached binaries of ad-hoc snippets and shebang scripts, keyed by content hash.
+// Defaults to <user cache dir>/goscript/bin. Set dir in the [cache] section of goscript.conf to move it.
+func cacheDir() string {
+	if dir := readProjectConfig()["cache"]["dir"]; dir != "" {
+		return dir
+	}
+	userCache, err := os.UserCacheDir()
+	if err != nil {
+		return projectDir + "/cache"
+	}
+	return filepath.Join(userCache, "goscript", "bin")
+}
+
+// The go env variables that change what go build produces, and so are part of the build cache key:
+// the toolchain, the target and its architecture level, experiments, and the cgo compilers and flags.
+var cacheKeyEnv = []string{
+	"GOVERSION", "GOOS", "GOARCH", "GOFLAGS", "GOEXPERIMENT",
+	"GOAMD64", "GOARM", "GOARM64", "GO386", "GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+	"CGO_ENABLED", "CC", "CXX", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_FFLAGS", "CGO_LDFLAGS",
+}
+
+// Return the path of the cached binary for the generated source. The key is a hash of everything
+// that goes into the build: the source (which includes the expanded template), the build flags and env
+// (see readBuildSettings), the project go.mod and go.sum, the project helper library (with its subpackages)
+// and the prelude if the source imports them, and the go env the build sees (see cacheKeyEnv).
+// Returns "" if the key can't be computed, in which case the build isn't cached.
+func cachedBinaryPath(source []byte) string {
+	settings, err := readBuildSettings(source)
+	if err != nil {
+		return "" //Not cached, so the build reports the error
+	}
+	cmd := exec.Command("go", append([]string{"env"}, cacheKeyEnv...)...)
+	cmd.Dir = projectDir
+	cmd.Env = append(os.Environ(), settings.Env...) //As goBuild runs it, e.g. with //goscript:env GOAMD64=v3
+	goEnv, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.New()
+	writeHashPart := func(label string, data []byte) {
+		fmt.Fprintf(hash, "%s %d\n", label, len(data))
+		hash.Write(data)
+	}
+	writeHashPart("source", source)
+	writeHashPart("go env", goEnv)
+	writeHashPart("build flags", []byte(strings.Join(settings.Flags, "\n")))
+	writeHashPart("build env", []byte(strings.Join(settings.Env, "\n")))
+	for _, name := range []string{"go.mod", "go.sum"} {
+		data, _ := os.ReadFile(projectDir + "/" + name)
+		writeHashPart(name, data)
+	}
+	if preludePath := preludeImportPath(); preludePath != "" && bytes.Contains(source, []byte(strconv.Quote(preludePath))) {
+		writeHashPart("prelude", []byte(preludeSource)) //Rewritten into the project when goscript's copy changes
+	}
+	if libPath := libImportPath(); libPath != "" && (bytes.Contains(source, []byte(strconv.Quote(libPath))) || bytes.Contains(source, []byte(`"`+libPath+`/`))) {
+		for _, file := range libFiles() {
+			data, _ := os.ReadFile(file)
+			rel, _ := filepath.Rel(projectDir, file)
+			writeHashPart(filepath.ToSlash(rel), data)
+		}
+	}
+
+	name := hex.EncodeToString(hash.Sum(nil))
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(cacheDir(), name)
+}
+
+// Check for a cached binary. On a hit, its modification time is updated, so the least recently
+// used binaries are the first removed when the cache is over its size limit.
+func isCached(cachedBin string) bool {
+	now := time.Now()
+	return os.Chtimes(cachedBin, now, now) == nil
+}
+
+// Compile the source into the cache. buildBinary renames the binary into place once it is complete,
+// so a concurrent run never executes a partly written binary. Returns the compiler output if the build fails.
+func compileCachedBinary(srcFilename, cachedBin string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(cachedBin), 0755)
+	if err != nil {
+		return "Unable to create the build cache", err
+	}
+	return buildBinary(srcFilename, cachedBin)
+}
+
+// A binary in the build cache.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// List the binaries in the build cache, least recently used first.
+func readCacheEntries(dir string) []cacheEntry {
+	var entries []cacheEntry
+	list, _ := os.ReadDir(dir)
+	for _, entry := range list {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		entries = append(entries, cacheEntry{filepath.Join(dir, entry.Name()), info.Size(), info.ModTime()})
+	}
+	slices.SortFunc(entries, func(a, b cacheEntry) int { return a.modTime.Compare(b.modTime) })
+	return entries
+}
+
+// Read the cache limits from the [cache] section of goscript.conf (max_size, e.g. 512M, and max_age, e.g. 30d or 72h).
+func readCacheLimits() (int64, time.Duration) {
+	config := readProjectConfig()["cache"]
+	sizeValue, ageValue := defaultCacheMaxSize, defaultCacheMaxAge
+	if config["max_size"] != "" {
+		sizeValue = config["max_size"]
+	}
+	if config["max_age"] != "" {
+		ageValue = config["max_age"]
+	}
+	maxSize, err := parseSize(sizeValue)
+	check(err, 2, "Invalid max_size in the [cache] section of goscript.conf")
+	maxAge, err := parseAge(ageValue)
+	check(err, 2, "Invalid max_age in the [cache] section of goscript.conf")
+	return int64(maxSize), maxAge
+}
+
+// Parse a duration as time.ParseDuration does, also accepting a number of days (e.g. 30d).
+func parseAge(age string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(age, "d"); found {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(age)
+}
+
+// Enforce the cache limits. Binaries not used within max_age are removed, then the least recently
+// used until the cache is within max_size. Returns the number of binaries and bytes removed.
+func pruneCache() (int, int64) {
+	maxSize, maxAge := readCacheLimits()
+	var total int64
+	entries := readCacheEntries(cacheDir())
+	for _, entry := range entries {
+		total += entry.size
+	}
+	var removed int
+	var freed int64
+	for _, entry := range entries {
+		isTmp := strings.HasSuffix(entry.path, ".tmp")
+		if time.Since(entry.modTime) <= maxAge && total <= maxSize && !(isTmp && time.Since(entry.modTime) > time.Hour) {
+			continue
+		}
+		if os.Remove(entry.path) == nil {
+			removed++
+			freed += entry.size
+			total -= entry.size
+		}
+	}
+	return removed, freed
+}
+
+// Handle --cache clean | clear | info.
+func manageCache(op string) {
+	dir := cacheDir()
+	switch op {
+	case "clean":
+		removed, freed := pruneCache()
+		fmt.Printf("Removed %d cached binaries (%s) from %s\n", removed, formatSize(freed), dir)
+	case "clear":
+		var removed int
+		var freed int64
+		for _, entry := range readCacheEntries(dir) {
+			if os.Remove(entry.path) == nil {
+				removed++
+				freed += entry.size
+			}
+		}
+		fmt.Printf("Removed %d cached binaries (%s) from %s\n", removed, formatSize(freed), dir)
+	case "info":
+		maxSize, maxAge := readCacheLimits()
+		var total int64
+		entries := readCacheEntries(dir)
+		for _, entry := range entries {
+			total += entry.size
+		}
+		fmt.Printf("%s\n%d cached binaries, %s (limits: %s, unused for %s)\n", dir, len(entries), formatSize(total), formatSize(maxSize), maxAge)
+	default:
+		check(fmt.Errorf("Unknown --cache operation %q. Use clean, clear or info.", op), 2, "")
+	}
+}
+
+// Format a number of bytes with a K, M or G suffix, as accepted by parseSize.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d", size)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Read the optional project configuration file <project>/goscript.conf. The format is a minimal
+// INI file with [section] headers, key = value pairs and # or ; comments. Values may be quoted.
+// Returns section -> key -> value. A missing file yields an empty configuration.
+//
+// Example:
+//
+//	[imports]
+//	rand = math/rand
+//	template = text/template
+//
+//	[vars]
+//	timeout = 30s
+func readProjectConfig() map[string]map[string]string {
+	config := make(map[string]map[string]string)
+	filename := projectDir + "/goscript.conf"
+	if !checkFileExists(filename) {
+		return config
+	}
+	file, err := os.Open(filename)
+	check(err, 2, "")
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if config[section] == nil {
+			config[section] = make(map[string]string)
+		}
+		config[section][strings.TrimSpace(key)] = value
+	}
+	check(scanner.Err(), 2, "Unable to read "+filename)
+	return config
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Handle --build-for <targets> <name>. Cross-compile the named command for each GOOS/GOARCH target
+// (e.g. linux/arm64,darwin/amd64,windows/amd64) into outDir as <name>_<os>_<arch>[.exe], and record
+// their checksums in outDir/SHA256SUMS. The command stays in the project.
+func buildForTargets(targetList string, args []string, outDir string) {
+	if len(args) != 1 {
+		check(fmt.Errorf("Usage: --build-for <os>/<arch>[,<os>/<arch>...] <name>"), 2, "")
+	}
+	name := args[0]
+	srcFilename := projectDir + "/src/" + name + ".go"
+	if !checkFileExists(srcFilename) {
+		check(fmt.Errorf("No command %s in %s", name, projectDir+"/src"), 2, "")
+	}
+	targets := parseTargets(targetList)
+
+	absOutDir, err := filepath.Abs(outDir) //go build runs in the project directory
+	check(err, 2, "")
+	err = os.MkdirAll(absOutDir, 0755)
+	check(err, 2, "Unable to create output directory "+outDir)
+
+	sums := readChecksums(absOutDir)
+	var failed int
+	for _, target := range targets {
+		goos, goarch, _ := strings.Cut(target, "/")
+		binName := name + "_" + goos + "_" + goarch
+		if goos == "windows" {
+			binName += ".exe"
+		}
+		binFilename := filepath.Join(absOutDir, binName)
+		out, err := buildBinary(srcFilename, binFilename, "GOOS="+goos, "GOARCH="+goarch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--- %s\n%s\n", target, strings.TrimSpace(out+"\n"+err.Error()))
+			failed++
+			continue
+		}
+		sum, err := fileChecksum(binFilename)
+		if check(err, 1, "Unable to checksum "+binFilename) {
+			failed++
+			continue
+		}
+		sums[binName] = sum
+		fmt.Println(filepath.Join(outDir, binName))
+	}
+
+	writeChecksums(absOutDir, sums)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d targets failed\n", failed, len(targets))
+		os.Exit(1)
+	}
+}
+
+// Split a comma separated list of GOOS/GOARCH targets and check each is supported by the Go toolchain.
+func parseTargets(targetList string) []string {
+	cmd := exec.Command("go", "tool", "dist", "list")
+	out, err := cmd.Output()
+	check(err, 2, "Unable to list the targets supported by the Go toolchain")
+	supported := strings.Fields(string(out))
+
+	var targets []string
+	for _, target := range strings.Split(targetList, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" || slices.Contains(targets, target) {
+			continue
+		}
+		if !slices.Contains(supported, target) {
+			check(fmt.Errorf("Unsupported target %q. Run 'go tool dist list' for the supported os/arch pairs.", target), 2, "")
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		check(fmt.Errorf("No targets given. Use e.g. --build-for linux/arm64,windows/amd64 <name>"), 2, "")
+	}
+	return targets
+}
+
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Read outDir/SHA256SUMS as filename -> checksum, so entries for other files are kept when it is rewritten.
+func readChecksums(outDir string) map[string]string {
+	sums := make(map[string]string)
+	file, err := os.Open(filepath.Join(outDir, "SHA256SUMS"))
+	if err != nil {
+		return sums
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		//sha256sum format: <checksum>, two spaces (or space and * for binary mode), <filename>
+		sum, binName, found := strings.Cut(scanner.Text(), " ")
+		if found {
+			sums[strings.TrimLeft(binName, " *")] = sum
+		}
+	}
+	return sums
+}
+
+// Write outDir/SHA256SUMS in the format read by 'sha256sum -c', sorted by filename.
+func writeChecksums(outDir string, sums map[string]string) {
+	var names []string
+	for binName := range sums {
+		names = append(names, binName)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	for _, binName := range names {
+		fmt.Fprintf(&sb, "%s  %s\n", sums[binName], binName)
+	}
+	err := os.WriteFile(filepath.Join(outDir, "SHA256SUMS"), []byte(sb.String()), 0644)
+	check(err, 1, "Unable to write "+filepath.Join(outDir, "SHA256SUMS"))
+}
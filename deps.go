@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The most times buildBinary runs go get and builds again before giving up. Each round fetches every
+// module the compiler asked for, so more than one is only needed when a fetched module needs another.
+const maxResolveRounds = 3
+
+// Matches the compiler's suggestion for a missing module, e.g. "go get github.com/bitfield/script".
+var goGetHint = regexp.MustCompile(`go get (\S+)`)
+
+// A module go get was run for while resolving a build's dependencies.
+type dependencyAttempt struct {
+	Module string
+	Err    error //nil if it was fetched
+}
+
+// The state of the resolve-and-retry loop of one build (see buildBinary).
+type dependencyResolution struct {
+	rounds   int
+	attempts []dependencyAttempt
+	stopped  string //Why the loop stopped, if it did
+}
+
+// Run go get for the modules the failed build output asks for. Reports whether the build should be
+// tried again. Stops if there are none (the build failed for another reason), if the project is
+// offline, if a module was already fetched but is still missing, if go get fails or after maxResolveRounds.
+func (d *dependencyResolution) resolve(out string) bool {
+	var missing []string
+	allPinned := true
+	for _, m := range goGetHint.FindAllStringSubmatch(out, -1) {
+		module := m[1]
+		if pinned, found := indexedVersions[module]; found {
+			module = pinned //The version already in the module cache, which needs no network
+		} else {
+			allPinned = false
+		}
+		if !slices.Contains(missing, module) {
+			missing = append(missing, module)
+		}
+	}
+	if len(missing) == 0 {
+		if len(d.attempts) > 0 {
+			d.stopped = "the modules were fetched, but the build still fails"
+		}
+		return false
+	}
+	for _, module := range missing {
+		if slices.ContainsFunc(d.attempts, func(a dependencyAttempt) bool { return a.Module == module }) {
+			d.stopped = module + " is still missing after go get"
+			d.attempts = append(d.attempts, dependencyAttempt{module, fmt.Errorf("still missing")})
+			return false
+		}
+	}
+	if d.rounds == maxResolveRounds {
+		d.stopped = fmt.Sprintf("gave up after %d rounds of go get", maxResolveRounds)
+		d.recordMissing(missing)
+		return false
+	}
+	if reason := offlineReason(); reason != "" && !allPinned {
+		d.stopped = reason
+		d.recordMissing(missing)
+		return false
+	}
+
+	d.rounds++
+	for _, module := range missing {
+		_, err := goGet(module)
+		d.attempts = append(d.attempts, dependencyAttempt{module, err})
+		if err != nil {
+			d.stopped = "go get " + module + " failed"
+			if isNetworkError(err.Error()) {
+				d.stopped += " (the module proxy or repository can't be reached. Are you offline?)"
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Record modules that were needed but not fetched.
+func (d *dependencyResolution) recordMissing(modules []string) {
+	for _, module := range modules {
+		d.attempts = append(d.attempts, dependencyAttempt{module, fmt.Errorf("not fetched")})
+	}
+}
+
+// A single report of what the build needed, what was fetched and why resolution stopped,
+// followed by the output of the last build.
+func (d *dependencyResolution) report(out string) string {
+	var sb strings.Builder
+	sb.WriteString("Unable to resolve the modules required by the build.\n")
+	for _, a := range d.attempts {
+		if a.Err == nil {
+			fmt.Fprintf(&sb, "  fetched  %s\n", a.Module)
+		} else {
+			fmt.Fprintf(&sb, "  missing  %s (%s)\n", a.Module, firstErrorLine(a.Err.Error()))
+		}
+	}
+	fmt.Fprintf(&sb, "Stopped because %s.\n", d.stopped)
+	sb.WriteString(strings.TrimSpace(out))
+	return sb.String()
+}
+
+// If go get can't or shouldn't change the project, return why: GOPROXY=off (no downloads) or
+// GOFLAGS with -mod=readonly or -mod=vendor (go.mod is not to be updated). Returns "" otherwise.
+func offlineReason() string {
+	cmd := exec.Command("go", "env", "GOPROXY", "GOFLAGS")
+	cmd.Dir = projectDir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(out), "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "off" {
+		return "GOPROXY=off, so modules can't be downloaded. Fetch them with --goget once online"
+	}
+	if len(lines) > 1 {
+		for _, flag := range strings.Fields(lines[1]) {
+			if flag == "-mod=readonly" || flag == "-mod=vendor" {
+				return "GOFLAGS has " + flag + ", so go.mod can't be updated. Add the modules with --goget"
+			}
+		}
+	}
+	return ""
+}
+
+// Report whether go get output shows the network is unreachable.
+func isNetworkError(out string) bool {
+	for _, s := range []string{"dial tcp", "no such host", "connection refused", "network is unreachable", "i/o timeout", "TLS handshake timeout"} {
+		if strings.Contains(out, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The file name prefix of the //line directives in an annotated snippet (see annotateText).
+// It is absolute so the compiler reports it as is, rather than relative to the src directory.
+const snippetMarker = "/goscript-snippet/"
+
+// Matches a compiler diagnostic in an annotated snippet, e.g. /goscript-snippet/--code:3:17: undefined: foo
+var snippetDiagnostic = regexp.MustCompile(`^` + regexp.QuoteMeta(snippetMarker) + `(.+):(\d+):(\d+): (.*)$`)
+
+// Add a /*line*/ directive before the first token of each line of text, a part of the snippet
+// named key (code, begin or end) that starts at offset in code. The compiler then reports positions
+// in the text as key:line:col in the original snippet, however the template wraps it. Lines inside a
+// token (e.g. a multi-line raw string) are left alone. A final directive marks whatever follows the
+// text as generated code.
+func annotateText(code, text string, offset int, key string) string {
+	if strings.TrimSpace(text) == "" {
+		return text //Nothing to report errors in, and templates may test for empty parts (e.g. {{with .Begin}})
+	}
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(text))
+	var s scanner.Scanner
+	s.Init(file, []byte(text), nil, 0)
+
+	var sb strings.Builder
+	last, lastLine := 0, 0
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		start := file.Offset(pos)
+		line := file.Line(pos)
+		if tok == token.SEMICOLON && start < len(text) && text[start] == '\n' {
+			continue //Inserted at the end of a line, not a token in the text
+		}
+		if line == lastLine {
+			continue
+		}
+		lastLine = line
+		origLine := 1 + strings.Count(code[:offset+start], "\n")
+		origCol := offset + start - strings.LastIndex(code[:offset+start], "\n")
+		sb.WriteString(text[last:start])
+		fmt.Fprintf(&sb, "/*line %s%s:%d:%d*/", snippetMarker, key, origLine, origCol)
+		last = start
+	}
+	sb.WriteString(text[last:])
+	fmt.Fprintf(&sb, "/*line %sgenerated:1:1*/", snippetMarker)
+	return sb.String()
+}
+
+// Split and annotate a snippet for diagnostics. Returns the same declarations and body as
+// splitSnippet, with each line marked with its position in the original code (see annotateText).
+func annotateSnippet(code string, moveVars bool) (string, string) {
+	parts, body := splitSnippetParts(code, moveVars)
+	var declTexts []string
+	for _, part := range parts {
+		declTexts = append(declTexts, annotateText(code, part.text, part.offset, "code"))
+	}
+	return strings.Join(declTexts, "\n\n"), annotateText(body, body, 0, "code")
+}
+
+// Report a failed build of a snippet. The snippet is rebuilt with /*line*/ directives so the compiler
+// reports positions in the user's code rather than the generated source, and each diagnostic is printed
+// with an excerpt of the offending line. Falls back to the original error and compiler output if there
+// are no diagnostics (e.g. invalid build flags) or any is outside the user's code (e.g. in the template).
+func reportSnippetErrors(out string, err error, repl Repl, tmplName string) {
+	if strings.TrimSpace(out) == "" {
+		check(err, 1, out) //Not a compile error, so there is nothing to map
+		return
+	}
+	sources := snippetSources(repl)
+	annotated := assembleSource(repl, tmplName, true)
+
+	scratchDir := createScratchDir()
+	writeSourceFile(scratchDir+"/main.go", annotated)
+	diagOut, _ := goBuild(scratchDir+"/main.go", os.DevNull, false) //The first build already ran go get for missing modules
+	os.RemoveAll(scratchDir)
+
+	var report strings.Builder
+	mapped := false
+	for _, line := range strings.Split(strings.TrimSpace(diagOut), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := snippetDiagnostic.FindStringSubmatch(line)
+		if m == nil {
+			if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "too many errors") {
+				report.WriteString(line + "\n") //Continuation of the previous diagnostic
+				continue
+			}
+			mapped = false
+			break
+		}
+		source, found := sources[m[1]]
+		if !found {
+			mapped = false //In the template or other generated code
+			break
+		}
+		lineNum, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		fmt.Fprintf(&report, "%s:%d:%d: %s\n%s", source.label, lineNum+source.skipped, col, m[4], excerpt(source.text, lineNum, col))
+		mapped = true
+	}
+	if !mapped {
+		check(err, 1, out)
+		return
+	}
+	fmt.Fprint(os.Stderr, report.String())
+}
+
+// A part of a snippet, as shown in diagnostics.
+type snippetSource struct {
+	label   string //The --code file if one was given, otherwise the option the text came from
+	text    string
+	skipped int //Lines of the file before text (the shebang readSourceFile strips), added to line numbers in the label
+}
+
+// The original text of each part of a snippet, keyed by the name used in its line directives (see annotateText).
+func snippetSources(repl Repl) map[string]snippetSource {
+	sources := map[string]snippetSource{
+		"code":  {"--code", repl.Code, 0},
+		"begin": {"--begin", repl.Begin, 0},
+		"end":   {"--end", repl.End, 0},
+	}
+	if repl.Format != "" {
+		sources["code"] = snippetSource{"--eval", repl.Code, 0}
+	}
+	if fileInfo, err := os.Stat(repl.Code); err == nil && !fileInfo.IsDir() {
+		//The same text assembleSource compiles, so positions in it match
+		code := readSourceFile(repl.Code).String()
+		sources["code"] = snippetSource{repl.Code, code, countShebangLines(repl.Code)}
+	}
+	return sources
+}
+
+// The line of code at lineNum with a caret under col, indented, e.g.
+//
+//	fmt.Println(foo)
+//	            ^
+func excerpt(code string, lineNum, col int) string {
+	lines := strings.Split(code, "\n")
+	if lineNum < 1 || lineNum > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[lineNum-1], "\r")
+	//Keep tabs in the caret line, so it lines up however wide tabs are
+	var caret bytes.Buffer
+	for i := 0; i < col-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	return fmt.Sprintf("    %s\n    %s^\n", line, caret.String())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnnotateText(t *testing.T) {
+	const generated = "/*line " + snippetMarker + "generated:1:1*/"
+	tests := []struct {
+		name   string
+		code   string
+		text   string //"" for all of code
+		offset int
+		key    string
+		want   string
+	}{
+		{
+			name: "statements",
+			code: "a := 1\nfmt.Println(a)",
+			key:  "code",
+			want: "/*line /goscript-snippet/code:1:1*/a := 1\n/*line /goscript-snippet/code:2:1*/fmt.Println(a)" + generated,
+		},
+		{
+			name: "indented",
+			code: "if true {\n\tf()\n}",
+			key:  "end",
+			want: "/*line /goscript-snippet/end:1:1*/if true {\n\t/*line /goscript-snippet/end:2:2*/f()\n/*line /goscript-snippet/end:3:1*/}" + generated,
+		},
+		{
+			name: "raw string",
+			code: "x := `a\nb`\n\tf(x)",
+			key:  "code",
+			want: "/*line /goscript-snippet/code:1:1*/x := `a\nb`\n\t/*line /goscript-snippet/code:3:2*/f(x)" + generated,
+		},
+		{
+			name:   "declaration after the first line",
+			code:   "x := 1\nfunc f() { g(x) }",
+			text:   "func f() { g(x) }",
+			offset: 7,
+			key:    "code",
+			want:   "/*line /goscript-snippet/code:2:1*/func f() { g(x) }" + generated,
+		},
+		{
+			name:   "declaration after code on the same line",
+			code:   "x := 1; func f() {}",
+			text:   "func f() {}",
+			offset: 8,
+			key:    "code",
+			want:   "/*line /goscript-snippet/code:1:9*/func f() {}" + generated,
+		},
+		{
+			name: "blank",
+			code: " \n ",
+			key:  "begin",
+			want: " \n ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text := test.text
+			if text == "" {
+				text = test.code
+			}
+			if got := annotateText(test.code, text, test.offset, test.key); got != test.want {
+				t.Errorf("annotateText() =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	const code = "x := 1\n\tfmt.Println(x, zz)\r\ny := 2"
+	tests := []struct {
+		name      string
+		line, col int
+		want      string
+	}{
+		{name: "first line", line: 1, col: 1, want: "    x := 1\n    ^\n"},
+		{name: "tab and carriage return", line: 2, col: 17, want: "    \tfmt.Println(x, zz)\n    \t               ^\n"},
+		{name: "last line", line: 3, col: 6, want: "    y := 2\n         ^\n"},
+		{name: "column past the end", line: 1, col: 20, want: "    x := 1\n          ^\n"},
+		{name: "line 0", line: 0, col: 1, want: ""},
+		{name: "line past the end", line: 4, col: 1, want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := excerpt(code, test.line, test.col); got != test.want {
+				t.Errorf("excerpt() =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSnippetSourcesShebang(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snip.txt")
+	err := os.WriteFile(filename, []byte("#!/usr/bin/env goscript\nx := 1\nfmt.Println(x, zz)\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := snippetSources(Repl{Code: filename})["code"]
+	if source.label != filename || source.skipped != 1 {
+		t.Errorf("label %q, skipped %d, want %q, 1", source.label, source.skipped, filename)
+	}
+	//Line numbers in diagnostics are for the text assembleSource compiles, without the shebang
+	if got, want := excerpt(source.text, 2, 16), "    fmt.Println(x, zz)\n                   ^\n"; got != want {
+		t.Errorf("excerpt() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A goimports-style pass for complete source files (--file, shebang scripts and named commands).
+// Adds imports for unresolved package references using the same alias tables as --code, and
+// removes imports that are never referenced. Returns the buffer unchanged, and prints nothing,
+// if there is nothing to fix or the file doesn't parse (the compiler will report that).
+// If lineFile is set (a --file built without a name, see addLineDirective), the source isn't reformatted
+// and a //line directive after the import block keeps the lines that follow at their place in lineFile.
+func fixImports(src *bytes.Buffer, lineFile string) *bytes.Buffer {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src.Bytes(), parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return src
+	}
+
+	refs := collectPackageRefs(fset, file)
+	used := make(map[string]bool)
+	for _, ref := range refs {
+		used[ref.Name] = true
+	}
+
+	//Work out the name each existing import is referenced by
+	var paths []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		paths = append(paths, path)
+	}
+	pkgNames := lookupPackageNames(paths)
+
+	var kept []string
+	var removed []string
+	imported := make(map[string]bool)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name, known := pkgNames[path]
+		if spec.Name != nil {
+			name, known = spec.Name.Name, true
+		} else if !known {
+			name = guessPackageName(path)
+		}
+		//Blank, dot and cgo imports are used implicitly, so they are always kept. So is an import
+		// whose package name is only a guess, rather than risk removing one that is needed.
+		if name == "_" || name == "." || path == "C" || !known || used[name] {
+			kept = append(kept, specSource(fset, src.Bytes(), spec))
+			imported[name] = true
+		} else {
+			removed = append(removed, spec.Path.Value)
+		}
+	}
+
+	var missing []pkgRef
+	for _, ref := range refs {
+		if !imported[ref.Name] {
+			missing = append(missing, ref)
+		}
+	}
+	added := resolveImports(missing)
+
+	if len(added) == 0 && len(removed) == 0 {
+		return src
+	}
+
+	//Replace the import declarations with a single block of the kept and added imports
+	var block strings.Builder
+	block.WriteString("import (\n")
+	for _, spec := range append(kept, added...) {
+		block.WriteString("\t" + spec + "\n")
+	}
+	block.WriteString(")")
+
+	blockText := block.String()
+	var start, end int
+	var importDecls []ast.Decl
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			importDecls = append(importDecls, decl)
+		}
+	}
+	if len(importDecls) > 0 {
+		start = fset.Position(importDecls[0].Pos()).Offset
+		end = fset.Position(importDecls[len(importDecls)-1].End()).Offset
+	} else {
+		//No imports yet, so add the block after the package clause
+		start = fset.Position(file.Name.End()).Offset
+		end = start
+		blockText = "\n\n" + blockText
+	}
+
+	fixed := bytes.NewBuffer([]byte{})
+	fixed.Write(src.Bytes()[:start])
+	fixed.WriteString(blockText)
+	rest := src.Bytes()[end:]
+	if lineFile == "" {
+		fixed.Write(rest)
+		formatCode(fixed)
+	} else if nl := bytes.IndexByte(rest, '\n'); nl >= 0 && len(bytes.TrimSpace(rest[:nl])) == 0 {
+		//The directive takes the place of the rest of the line the imports ended on
+		line := 1 + bytes.Count(src.Bytes()[:end+nl+1], []byte("\n"))
+		fixed.WriteString("\n" + lineDirective(lineFile, line))
+		fixed.Write(rest[nl+1:])
+	} else {
+		fixed.Write(rest) //Code follows the imports on the same line, so later lines may be reported a few lines out
+	}
+
+	if len(added) > 0 {
+		fmt.Fprintf(os.Stderr, "Added imports: %s\n", strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(os.Stderr, "Removed unused imports: %s\n", strings.Join(removed, ", "))
+	}
+	return fixed
+}
+
+// The source text of an import spec, including its name and any trailing line comment.
+func specSource(fset *token.FileSet, src []byte, spec *ast.ImportSpec) string {
+	end := spec.End()
+	if spec.Comment != nil {
+		end = spec.Comment.End()
+	}
+	return string(src[fset.Position(spec.Pos()).Offset:fset.Position(end).Offset])
+}
+
+// Look up the package clause name for each import path with 'go list'. Paths that can't be
+// listed (e.g. modules not yet in go.mod) are left out of the result.
+func lookupPackageNames(paths []string) map[string]string {
+	names := make(map[string]string)
+	if len(paths) == 0 {
+		return names
+	}
+	args := append([]string{"list", "-e", "-f", "{{.ImportPath}} {{.Name}}"}, paths...)
+	cmd := exec.Command("go", args...)
+	cmd.Dir = projectDir
+	out, _ := cmd.Output()
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		importPath, name, found := strings.Cut(line, " ")
+		if found && name != "" {
+			names[importPath] = name
+		}
+	}
+	return names
+}
+
+var majorVersionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// Guess a package name from its import path the way goimports does: use the last element,
+// skipping a /vN major version suffix, then drop a .vN suffix (gopkg.in), a go- prefix and
+// anything up to the last hyphen (e.g. gopkg.in/yaml.v3 -> yaml, github.com/x/go-chi/v5 -> chi).
+func guessPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersionSuffix.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	if i := strings.Index(name, ".v"); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// An alias seen in the import blocks of the project sources.
+type learnedAlias struct {
+	Alias string
+	Path  string
+	Files []string
+}
+
+// Handle --learn-imports. Scan the import blocks of every command in <project>/src, collect the
+// names each package is imported as (explicit aliases like re "regexp" and implicit package names),
+// report how they compare with the current imports table and offer to merge the new ones into imports.json.
+func learnImports() {
+	learned, inconsistent := scanSourceImports()
+	entries := loadAliasEntries()
+
+	var proposed, conflicts []learnedAlias
+	for _, l := range learned {
+		if slices.Contains(inconsistent, l.Alias) {
+			continue
+		}
+		entry, found := entries[l.Alias]
+		if !found {
+			proposed = append(proposed, l)
+		} else if entry.Path != l.Path {
+			conflicts = append(conflicts, l)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(proposed) > 0 {
+		fmt.Fprintln(w, "New aliases:")
+		for _, l := range proposed {
+			fmt.Fprintf(w, "  %s\t%s\t(%s)\n", l.Alias, l.Path, strings.Join(l.Files, ", "))
+		}
+	}
+	if len(conflicts) > 0 {
+		fmt.Fprintln(w, "Conflicts with existing aliases (not merged):")
+		for _, l := range conflicts {
+			entry := entries[l.Alias]
+			fmt.Fprintf(w, "  %s\t%s\t(%s)\tcurrently %s (%s)\n", l.Alias, l.Path, strings.Join(l.Files, ", "), entry.Path, entry.Source)
+		}
+	}
+	if len(inconsistent) > 0 {
+		fmt.Fprintln(w, "Aliases used for different packages across sources (not merged):")
+		for _, l := range learned {
+			if slices.Contains(inconsistent, l.Alias) {
+				fmt.Fprintf(w, "  %s\t%s\t(%s)\n", l.Alias, l.Path, strings.Join(l.Files, ", "))
+			}
+		}
+	}
+	w.Flush()
+
+	if len(proposed) == 0 {
+		fmt.Println("No new aliases to merge into imports.json.")
+		return
+	}
+	fmt.Printf("Merge %d new aliases into imports.json? [y/N] ", len(proposed))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		fmt.Println("Nothing merged.")
+		return
+	}
+
+	unlock := lockProject(true)
+	defer unlock()
+	userImports := readUserImports()
+	if userImports == nil {
+		userImports = make(map[string]string)
+	}
+	for _, l := range proposed {
+		userImports[l.Alias] = l.Path
+	}
+	writeUserImports(userImports)
+	fmt.Printf("Merged %d aliases into imports.json.\n", len(proposed))
+}
+
+// Parse the imports of each source in <project>/src. Returns every distinct alias -> path pair
+// sorted by alias, and the aliases that are used for more than one import path.
+// Blank and dot imports don't name a package and are ignored.
+func scanSourceImports() ([]learnedAlias, []string) {
+	type fileImport struct {
+		name string //explicit alias, or "" if the package name is implicit
+		path string
+		file string
+	}
+	var imports []fileImport
+	var paths []string
+
+	fset := token.NewFileSet()
+	for _, entry := range getSourceList() {
+		if !strings.HasSuffix(entry, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(projectDir, "src", entry), nil, parser.ImportsOnly)
+		if check(err, 1, "Skipping "+entry) {
+			continue
+		}
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			var name string
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if name == "_" || name == "." || path == "C" {
+				continue
+			}
+			imports = append(imports, fileImport{name, path, strings.TrimSuffix(entry, ".go")})
+			if !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	pkgNames := lookupPackageNames(paths)
+	var learned []learnedAlias
+	for _, imp := range imports {
+		alias := imp.name
+		if alias == "" {
+			alias = pkgNames[imp.path]
+			if alias == "" {
+				alias = guessPackageName(imp.path)
+			}
+		}
+		i := slices.IndexFunc(learned, func(l learnedAlias) bool { return l.Alias == alias && l.Path == imp.path })
+		if i < 0 {
+			learned = append(learned, learnedAlias{Alias: alias, Path: imp.path})
+			i = len(learned) - 1
+		}
+		if !slices.Contains(learned[i].Files, imp.file) {
+			learned[i].Files = append(learned[i].Files, imp.file)
+		}
+	}
+	slices.SortFunc(learned, func(a, b learnedAlias) int {
+		if c := strings.Compare(a.Alias, b.Alias); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	var inconsistent []string
+	for i := 1; i < len(learned); i++ {
+		if learned[i].Alias == learned[i-1].Alias && !slices.Contains(inconsistent, learned[i].Alias) {
+			inconsistent = append(inconsistent, learned[i].Alias)
+		}
+	}
+	return learned, inconsistent
+}
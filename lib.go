@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The alias (and package name) of the project helper library <project>/lib. Commands can call its
+// exported functions (e.g. lib.Must(err)) and the import is added like any other.
+const libAlias = "lib"
+
+// The helper library written to <project>/lib/lib.go by --setup.
+const libSource = `// Package lib holds helpers shared by the commands in src. Anything exported here can be used
+// from --code as lib.Name, and the import is added automatically.
+package lib
+
+import (
+	"fmt"
+	"os"
+)
+
+// Must exits with the error message if err is not nil.
+func Must(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`
+
+// Return the module path declared in the project go.mod, or "" if it can't be read.
+func readModulePath() string {
+	file, err := os.Open(projectDir + "/go.mod")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); found {
+			path = strings.TrimSpace(path)
+			if unquoted, err := strconv.Unquote(path); err == nil {
+				path = unquoted
+			}
+			return path
+		}
+	}
+	return ""
+}
+
+// The import path of the project helper library, or "" if the project has no lib directory.
+func libImportPath() string {
+	if !checkFileExists(projectDir + "/lib") {
+		return ""
+	}
+	modulePath := readModulePath()
+	if modulePath == "" {
+		return ""
+	}
+	return modulePath + "/" + libAlias
+}
+
+// Create <project>/lib with a starter helper, unless it already exists.
+func createLib() {
+	libDir := projectDir + "/lib"
+	if checkFileExists(libDir) {
+		return
+	}
+	os.Mkdir(libDir, 0766)
+	err := os.WriteFile(libDir+"/lib.go", []byte(libSource), 0644)
+	check(err, 1, "Unable to create "+libDir+"/lib.go")
+}
+
+// Report whether the source file imports the project helper library or one of its subpackages.
+func usesLib(srcFilename, libPath string) bool {
+	if libPath == "" {
+		return false
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), srcFilename, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == libPath || strings.HasPrefix(path, libPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the Go files of the project helper library, including those of its subpackages.
+func libFiles() []string {
+	var files []string
+	filepath.WalkDir(projectDir+"/"+libAlias, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// Compile the helper library on its own, so errors in it are reported once rather than for every command that uses it.
+func buildLib() bool {
+	cmd := exec.Command("go", "build", "./"+libAlias)
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	return !check(err, 1, fmt.Sprintf("Unable to build %s/%s\n%s", projectDir, libAlias, out))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Concurrent goscript invocations (e.g. shebang scripts run from xargs -P or cron) share the project's
+// go.mod, go.sum, imports.json, src and bin. They coordinate with advisory file locks in <project>/.locks:
+//
+//   - project.lock: held shared while go build reads go.mod and go.sum, and exclusively while go get,
+//     go mod tidy or an imports.json update changes them (see lockProject)
+//   - cmd-<name>.lock: held while the source and binary of a named command are written (see lockCommand)
+//
+// The locks are released when the process exits, so a killed goscript never leaves the project locked.
+
+// Lock <project>/.locks/<name>.lock, waiting until it is available. A shared lock can be held by several
+// processes at once, an exclusive lock by one only. Returns the function that releases the lock.
+// If the lock file can't be created (e.g. a read-only project), goscript warns and carries on unlocked.
+func acquireLock(name string, exclusive bool) func() {
+	lockDir := projectDir + "/.locks"
+	err := os.MkdirAll(lockDir, 0755)
+	if check(err, 1, "Unable to create the lock directory. Continuing without locking.") {
+		return func() {}
+	}
+	file, err := os.OpenFile(lockDir+"/"+name+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if check(err, 1, "Unable to open the lock file. Continuing without locking.") {
+		return func() {}
+	}
+	err = lockFile(file, exclusive)
+	if check(err, 1, "Unable to lock "+file.Name()+". Continuing without locking.") {
+		file.Close()
+		return func() {}
+	}
+	return func() { file.Close() } //Closing the file releases the lock
+}
+
+// Lock go.mod, go.sum and imports.json: shared to build against them, exclusive to change them.
+func lockProject(exclusive bool) func() {
+	return acquireLock("project", exclusive)
+}
+
+// Lock the source and binary of a named command while they are written.
+func lockCommand(name string) func() {
+	return acquireLock("cmd-"+name, true)
+}
+
+// A suffix for temporary file names that is unique across processes and goroutines: the process id
+// and random hex, e.g. 4242-9f86d081884c7d65.
+func uniqueSuffix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(b))
+}
+
+// Write the file by writing a temporary file in the same directory and renaming it into place,
+// so a concurrent reader sees the old or the new content and never a partly written file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmpFilename := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+"."+uniqueSuffix()+".tmp")
+	err := os.WriteFile(tmpFilename, data, perm)
+	if err == nil {
+		err = os.Rename(tmpFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package main
+
+import "os"
+
+// File locking isn't supported on this platform. Concurrent invocations aren't coordinated.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
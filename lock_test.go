@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Output of a named command written by TestConcurrentRuns, e.g. "hammer2 14".
+var hammerOutput = regexp.MustCompile(`^(hammer\d+) (\d+)$`)
+
+// Hammer a new project with concurrent goscript runs (ad-hoc snippets, named commands competing for
+// the same names and imports.json updates), then check that the project survived: every run succeeded,
+// imports.json and go.mod are intact, each named command's binary matches its source and no temporary
+// files are left behind.
+func TestConcurrentRuns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds goscript and many commands")
+	}
+	const runs, names = 16, 3
+	tmp := t.TempDir()
+	goscript := filepath.Join(tmp, "goscript")
+	out, err := exec.Command("go", "build", "-o", goscript, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Unable to build goscript: %v\n%s", err, out)
+	}
+	project := filepath.Join(tmp, "project")
+	env := append(os.Environ(), "GOSCRIPT_PROJECT_DIR="+project)
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(goscript, args...)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	cmd := exec.Command(goscript, "--setup", project)
+	cmd.Env = append(os.Environ(), "GOSCRIPT_PROJECT_DIR=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("Unable to create a project with --setup (offline?): %v\n%s", err, out)
+	}
+	config := "[cache]\ndir = " + filepath.Join(tmp, "cache") + "\n" //Keep the user's build cache out of it
+	if err := os.WriteFile(filepath.Join(project, "goscript.conf"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(3)
+		go func() { //Ad-hoc snippet, a cache miss each time so it is built in a scratch directory
+			defer wg.Done()
+			want := fmt.Sprintf("adhoc %d", i)
+			out, err := run("-x", "-c", fmt.Sprintf("fmt.Println(%q)", want))
+			if err != nil || out != want {
+				t.Errorf("ad-hoc run %d: %v %q", i, err, out)
+			}
+		}()
+		go func() { //Named command, written and built by several runs at once
+			defer wg.Done()
+			name := fmt.Sprintf("hammer%d", i%names)
+			out, err := run("-n", name, "-c", fmt.Sprintf("fmt.Println(%q, %d)", name, i))
+			if err != nil {
+				t.Errorf("named run %s (%d): %v %q", name, i, err, out)
+			}
+		}()
+		go func() { //imports.json read-modify-write
+			defer wg.Done()
+			out, err := run("--alias", "add", fmt.Sprintf("hammeralias%d=strings", i))
+			if err != nil {
+				t.Errorf("alias add %d: %v %q", i, err, out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	//imports.json must be valid and hold every alias added
+	var userImports map[string]string
+	data, err := os.ReadFile(filepath.Join(project, "imports.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &userImports)
+	}
+	if err != nil {
+		t.Errorf("imports.json: %v", err)
+	}
+	for i := range runs {
+		if alias := fmt.Sprintf("hammeralias%d", i); userImports[alias] != "strings" {
+			t.Errorf("imports.json: %s is missing", alias)
+		}
+	}
+
+	//go.mod must still load
+	cmd = exec.Command("go", "list", "-m", "all")
+	cmd.Dir = project
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go.mod: %v\n%s", err, out)
+	}
+
+	//Each named command's binary must be built from its source (the last write wins for both)
+	for n := range names {
+		name := fmt.Sprintf("hammer%d", n)
+		out, err := exec.Command(filepath.Join(project, "bin", name)).Output()
+		m := hammerOutput.FindStringSubmatch(strings.TrimSpace(string(out)))
+		if err != nil || m == nil || m[1] != name {
+			t.Errorf("%s: unexpected output %v %q", name, err, out)
+			continue
+		}
+		src, _ := os.ReadFile(filepath.Join(project, "src", name+".go"))
+		if !strings.Contains(string(src), fmt.Sprintf("fmt.Println(%q, %s)", name, m[2])) {
+			t.Errorf("%s: the binary prints %s, which doesn't match the source", name, m[2])
+		}
+	}
+
+	//No scratch directories, temporary sources or binaries, or half written files may be left
+	for _, pattern := range []string{".scratch/*", "src/gocmd-*", "bin/gocmd-*", "src/.*.tmp", "bin/*.tmp", ".*.tmp"} {
+		leftovers, _ := filepath.Glob(filepath.Join(project, pattern))
+		for _, leftover := range leftovers {
+			t.Errorf("left behind: %s", leftover)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx = kernel32.NewProc("LockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+// Lock the whole file with LockFileEx, waiting until it is available.
+func lockFile(file *os.File, exclusive bool) error {
+	var flags uintptr
+	if exclusive {
+		flags = lockfileExclusiveLock
+	}
+	overlapped := new(syscall.Overlapped)
+	ok, _, err := procLockFileEx.Call(file.Fd(), flags, 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
@@ -13,79 +13,134 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"text/template"
 	"time"
-
-	"github.com/fkmiec/goscript/util"
 )
 
 type Repl struct {
 	Imports []string
+	Decls   string //Top-level declarations (funcs, methods, types) split out of the code
 	Code    string
+
+	//Line processing modes (--lines and --print-lines, see templates/lines.tmpl)
+	Begin       string //Code to run before the first line
+	End         string //Code to run after the last line
+	FieldSep    string //Separator used to split each line into fields. Whitespace if empty.
+	PrintLines  bool   //Print each (possibly modified) line after the code runs
+	MaxLineSize int    //Maximum line length the scanner will accept
+
+	//Expression mode (--eval, see templates/eval.tmpl)
+	Format string //How to print each result: v, +v, #v or json
+
+	Vars    map[string]string //User-defined variables from -D name=value and the [vars] section of goscript.conf
+	Prelude bool              //Dot-import the prelude package (--prelude), so its helpers can be called as Die, Must, etc.
 }
 
 var version string = "goscript v1.2.3"
 var projectDir string
-var pkgMatcher *regexp.Regexp
 var buf *bytes.Buffer
 var savedErrors []string
 
-func assembleSourceFile(code string) *bytes.Buffer {
+func assembleSourceFile(repl Repl, tmplName string) *bytes.Buffer {
+	return assembleSource(repl, tmplName, false)
+}
+
+// Assemble the source file for a snippet. If annotate, each line of the snippet is marked with its
+// position in the user's code, and the source isn't formatted (see reportSnippetErrors).
+func assembleSource(repl Repl, tmplName string, annotate bool) *bytes.Buffer {
 	//If user wants to put main function body in a file and read it in, rather than cumbersome command line, we can do that.
-	if checkFileExists(code) {
-		buf = readSourceFile(code)
-		code = buf.String()
+	if fileInfo, err := os.Stat(repl.Code); err == nil && !fileInfo.IsDir() { //Not checkFileExists, since long code fails to stat with ENAMETOOLONG
+		buf = readSourceFile(repl.Code)
+		repl.Code = buf.String()
 	}
 	//Automate imports when writing a one-liner goscript with the --code option.
 
+	//Move any func, method and type declarations (and, for the script template, a leading var/const preamble) to file scope
+	moveVars := tmplName == "" || tmplName == defaultTemplate
+	decls, body := splitSnippet(repl.Code, moveVars)
+	if annotate {
+		decls, body = annotateSnippet(repl.Code, moveVars)
+		repl.Begin = annotateText(repl.Begin, repl.Begin, 0, "begin")
+		repl.End = annotateText(repl.End, repl.End, 0, "end")
+	}
+	repl.Decls, repl.Code = decls, strings.TrimSpace(body)
+
 	//Lookup any references to packages listed in the util/imports.go file and
 	// add to the imports if not already there explicitly. Enable use of shorter aliases.
+	body = repl.Begin + "\n" + repl.Code + "\n" + repl.End
+	if repl.Format != "" {
+		body = "printResults(" + repl.Code + ")" //An --eval expression (or list of them) only parses as call arguments
+	}
+	refs := findPackageRefs(repl.Decls, body)
+	declared := templateDeclaredNames(tmplName, repl)
+	refs = slices.DeleteFunc(refs, func(ref pkgRef) bool { return declared[ref.Name] }) //e.g. w and r in the http template
+	repl.Imports = resolveImports(refs)
+	if preludePath := preludeImportPath(); repl.Prelude && preludePath != "" && usesPrelude(repl.Decls, body) {
+		repl.Imports = append(repl.Imports, ". \""+preludePath+"\"")
+	}
+
+	buf = processTemplate(tmplName, repl)
+	buf = bytes.NewBuffer(append([]byte(templateHeader(tmplName)), buf.Bytes()...))
+	if !annotate {
+		formatCode(buf)
+	}
+	return buf
+}
+
+// Resolve package references to formatted import specs (e.g. "regexp" or re "regexp"), in the
+// form used by the template. References that can't be resolved are left for the compiler to report.
+func resolveImports(refs []pkgRef) []string {
 	var formattedImports []string
 
-	//Read in any additional import mappings from imports.json file in project directory
-	userImports := readUserImports()
-	if userImports != nil {
-		for key, value := range userImports {
-			util.ImportsMap[key] = value
-		}
-	}
-
-	pkgMatcher = regexp.MustCompile(`(\w+)\.`) //match a type, field or function accessor (e.g. pkg.Type or struct.Field or struct.Function)
-	matches := pkgMatcher.FindAllStringSubmatch(code, -1)
-	for _, m := range matches {
-		if len(m) > 0 {
-			k := m[1]
-			v := util.ImportsMap[k]
-
-			if v != "" {
-				//Check if the key matches the basename for the import. If so, use the import as is.
-				//Otherwise, prepend the key as an alias for the package (e.g. "re" instead of "regexp")
-				if filepath.Base(v) != k {
-					v = fmt.Sprintf("%s \"%s\"", k, v) //e.g. re "regexp"
-				} else {
-					v = fmt.Sprintf("\"%s\"", v) //e.g. "regexp"
-				}
-				//Ensure we don't duplicate any imports
-				if !slices.Contains(formattedImports, v) {
-					formattedImports = append(formattedImports, v)
+	//Combine the built-in map with the generated stdimports.json and user imports.json files in project directory
+	importsMap := loadImportsMap()
+	candidates := loadImportCandidates()
+
+	//Check each unresolved package reference (e.g. pkg.Func) against the map
+	for _, ref := range refs {
+		k := ref.Name
+		v, err := resolveImport(ref, importsMap, candidates)
+		check(err, 2, "")
+
+		//Fall back to packages in modules required by go.mod or already in the module cache
+		if v == "" {
+			pkgs := lookupPackageIndex(k)
+			if len(pkgs) == 1 || (len(pkgs) > 1 && pkgs[0].Rank == rankDirect && pkgs[1].Rank > rankDirect) {
+				v = pkgs[0].ImportPath //Only match, or the only match required directly by go.mod
+				if pkgs[0].Rank == rankModCache {
+					indexedVersions[v] = v + "@" + pkgs[0].Version
 				}
+			} else if len(pkgs) > 1 {
+				suggestPackages(k, pkgs)
 			}
 		}
-	}
 
-	repl := Repl{
-		Imports: formattedImports,
-		Code:    code,
+		if v != "" {
+			v = formatImport(k, v)
+			//Ensure we don't duplicate any imports
+			if !slices.Contains(formattedImports, v) {
+				formattedImports = append(formattedImports, v)
+			}
+		}
 	}
+	return formattedImports
+}
 
-	buf = processTemplate(repl)
-	formatCode(buf)
-	return buf
+func formatImport(alias, path string) string {
+	//Check if the alias matches the basename for the import. If so, use the import as is.
+	//Otherwise, prepend the alias for the package (e.g. "re" instead of "regexp")
+	if filepath.Base(path) != alias {
+		return fmt.Sprintf("%s \"%s\"", alias, path) //e.g. re "regexp"
+	}
+	return fmt.Sprintf("\"%s\"", path) //e.g. "regexp"
 }
 
 func formatCode(buf *bytes.Buffer) {
@@ -115,43 +170,114 @@ func writeUserImports(userImports map[string]string) {
 	filename := projectDir + "/imports.json"
 	jsonData, err := json.MarshalIndent(userImports, "", "    ") // Use MarshalIndent for pretty printing
 	check(err, 2, "Unable to marshal content for imports.json file.")
-	err = os.WriteFile(filename, jsonData, 0644)
+	err = writeFileAtomic(filename, jsonData, 0644)
 	check(err, 2, "")
 }
 
-func goGet(pkgName string) {
+// Go get a package or module (optionally with @version or /...) into the project and register an
+// alias in imports.json for each importable package. Returns the packages registered, or an error
+// with the go get output if it fails. Holds the project lock, so concurrent builds wait for go.mod.
+func goGet(pkgName string) ([]listedPackage, error) {
+	unlock := lockProject(true)
+	defer unlock()
 
 	//If no changes to go.mod in a week, run go mod tidy
 	//Intent is to NOT run go mod tidy every time goGet is required.
 	//	For unnamed code (e.g. shebang script), could result in go get for every invocation.
 	fileInfo, err := os.Stat(projectDir + "/go.mod")
-	check(err, 2, "Could not stat go.mod file.")
+	if err != nil {
+		return nil, fmt.Errorf("Could not stat go.mod file. %v", err)
+	}
 	if fileInfo.ModTime().Before(time.Now().Add(-7 * 24 * time.Hour)) {
-		goTidy()
+		check(runModTidy(), 1, "") //Only housekeeping, so carry on with the go get if it fails
 	}
 
 	cmd := exec.Command("go", "get", pkgName)
 	cmd.Dir = projectDir
 
 	out, err := cmd.CombinedOutput()
-	check(err, 2, fmt.Sprintf("%v: %s", err, out))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	//Add an alias for each importable package to imports.json file, named by its actual package clause
+	// (e.g. yaml for gopkg.in/yaml.v3 and chi for github.com/go-chi/chi/v5, not v3 or v5)
+	pkgPath, _, _ := strings.Cut(pkgName, "@") //strip any version query (e.g. @v1.2.3 or @latest)
+	pkgs := listPackages(pkgPath)
+	if len(pkgs) == 0 && !strings.HasSuffix(pkgPath, "/...") {
+		//The module root may not be a package itself (e.g. only subdirectories contain Go files)
+		pkgs = listPackages(pkgPath + "/...")
+	}
+	if len(pkgs) == 0 {
+		fmt.Fprintf(os.Stderr, "No importable packages found in %s. No aliases added to imports.json.\n", pkgPath)
+		return nil, nil
+	}
 
-	//Add pkgName to imports.json file
-	pkgAlias := filepath.Base(pkgName)
 	userImports := readUserImports()
 	if userImports == nil {
 		userImports = make(map[string]string)
 	}
-	userImports[pkgAlias] = pkgName
+	var registered []listedPackage
+	for _, pkg := range pkgs {
+		i := slices.IndexFunc(registered, func(r listedPackage) bool { return r.Name == pkg.Name })
+		if i >= 0 {
+			fmt.Fprintf(os.Stderr, "Package name %s is used by both %s and %s. Keeping %s.\n", pkg.Name, registered[i].ImportPath, pkg.ImportPath, registered[i].ImportPath)
+			continue
+		}
+		registered = append(registered, pkg)
+		userImports[pkg.Name] = pkg.ImportPath
+	}
 	writeUserImports(userImports)
+	return registered, nil
+}
+
+// An importable package reported by 'go list'.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+}
+
+// List the importable packages matching pattern (a package path, or a path ending in /... for
+// every package in a module) as resolved in the project module, shortest import path first.
+// Commands (package main) and internal packages are excluded. Returns nil if nothing matches.
+func listPackages(pattern string) []listedPackage {
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", pattern)
+	cmd.Dir = projectDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var pkgs []listedPackage
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		importPath, name, found := strings.Cut(line, " ")
+		if !found || name == "main" || !isImportable(importPath, "") {
+			continue
+		}
+		pkgs = append(pkgs, listedPackage{ImportPath: importPath, Name: name})
+	}
+	slices.SortFunc(pkgs, func(a, b listedPackage) int {
+		return compareCandidates("", a.ImportPath, b.ImportPath)
+	})
+	return pkgs
 }
 
-func goTidy() {
+// Run go mod tidy in the project, holding the project lock.
+func goTidy() error {
+	unlock := lockProject(true)
+	defer unlock()
+	return runModTidy()
+}
+
+// Run go mod tidy. The caller holds the project lock (see goGet).
+func runModTidy() error {
 	cmd := exec.Command("go", "mod", "tidy")
 	cmd.Dir = projectDir
 
 	out, err := cmd.CombinedOutput()
-	check(err, 2, fmt.Sprintf("%v: %s\n", err, out))
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
 }
 
 func editCommand(cmd string) {
@@ -203,15 +329,43 @@ func readSourceFile(filename string) *bytes.Buffer {
 	return buf
 }
 
-func processTemplate(repl Repl) *bytes.Buffer {
+// Count the shebang lines readSourceFile strips from a file. Since a shebang is the first line,
+// line numbers in the text it returns are this many lines less than in the file.
+func countShebangLines(filename string) int {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#!") {
+			count++
+		}
+	}
+	return count
+}
 
-	//go(:)embed script.tmpl
-	//var vfs embed.FS
-	//tmpl, err := template.New("script.tmpl").ParseFS(vfs, "script.tmpl") //Embedding the template would be more efficient, but not embedding lets user change it w/o recompile.
+func processTemplate(tmplName string, repl Repl) *bytes.Buffer {
 
-	var tmplFile = projectDir + "/script.tmpl"
-	tmpl, err := template.New("script.tmpl").ParseFiles(tmplFile)
+	//Templates are embedded in goscript. A copy in the project overrides the embedded one, so the user can change it w/o recompile.
+	text, source, err := readTemplate(tmplName)
 	check(err, 2, "")
+	tmpl, err := template.New(filepath.Base(templateFile(tmplName))).Funcs(templateFuncs).Parse(string(text))
+	check(err, 2, "Unable to parse template "+source)
+	if repl.Decls != "" && !strings.Contains(string(text), ".Decls") {
+		check(fmt.Errorf("The code declares functions or types, but template %s has no place for them. Add {{.Decls}} after the imports.", source), 2, "")
+	}
+
+	//Add the default imports declared by the template, ahead of those found in the code
+	var imports []string
+	for _, imp := range append(templateImports(string(text)), repl.Imports...) {
+		if !slices.Contains(imports, imp) {
+			imports = append(imports, imp)
+		}
+	}
+	repl.Imports = imports
 
 	buf = bytes.NewBuffer([]byte{})
 	err = tmpl.Execute(buf, repl)
@@ -222,15 +376,9 @@ func processTemplate(repl Repl) *bytes.Buffer {
 
 func writeSourceFile(filename string, buf *bytes.Buffer) bool {
 
-	// Open the file for writing, creates it if it doesn't exist, or truncates if it exists.
-	file, err := os.Create(filename)
-	check(err, 2, "")
-
-	// Ensure the file is closed after the function returns.
-	defer file.Close()
-
-	// Write the buffer to the file
-	_, err = buf.WriteTo(file)
+	// Replace the file in one step, so a concurrent build never reads a partly written source.
+	// The buffer isn't drained, so it can be written again (e.g. for --keep).
+	err := writeFileAtomic(filename, buf.Bytes(), 0644)
 	check(err, 2, "")
 
 	return true
@@ -293,6 +441,8 @@ func getSourceList() []string {
 
 // Soft delete. Renames source file without .go extension so it will be ignored. Removes binary.
 func deleteCommand(cmd string) {
+	unlock := lockCommand(cmd)
+	defer unlock()
 	sansGoExt := projectDir + "/src/" + cmd
 	srcFilename := sansGoExt + ".go"
 	binFilename := projectDir + "/bin/" + cmd
@@ -300,11 +450,13 @@ func deleteCommand(cmd string) {
 	check(err, 1, "")
 	err = os.Remove(binFilename)
 	check(err, 1, "")
-	goTidy() //run go mod tidy to keep go.mod file current when you remove sources
+	check(goTidy(), 1, "") //run go mod tidy to keep go.mod file current when you remove sources
 }
 
 // Soft delete. Renames source file without .go extension so it will be ignored. Removes binary.
 func restoreCommand(cmd string) {
+	unlock := lockCommand(cmd)
+	defer unlock()
 	sansGoExt := projectDir + "/src/" + cmd
 	srcFilename := sansGoExt + ".go"
 	binFilename := projectDir + "/bin/" + cmd
@@ -313,42 +465,183 @@ func restoreCommand(cmd string) {
 	compileBinary(srcFilename, binFilename)
 }
 
-func recompileCommands() {
-	commands := getSourceList()
-	var srcFilename, binFilename string
-	for _, name := range commands {
+// The result of recompiling one command, for the --recompile summary.
+type recompileResult struct {
+	Name     string
+	Reasons  []string //Why the command was stale (see staleReasons)
+	Duration time.Duration
+	Output   string //Compiler output if the build failed
+}
+
+// Recompile the stale commands in the project src directory (see staleReasons), or all of them if force,
+// with the given number of parallel builds. If onlyLibUsers, only those that import the project helper library.
+// Failures don't stop the other builds. A summary of every build is printed at the end, and goscript exits
+// with status 1 if any failed. A --profile applies to these builds only, unless saveProfile, in which case it
+// is saved in the header of each command that built (see saveProfileHeader).
+func recompileCommands(onlyLibUsers, force, saveProfile bool, jobs int) {
+	if buildProfile != "" {
+		_, err := readBuildSettings(nil) //Check the profile exists before building anything with it
+		check(err, 2, "")
+	} else if saveProfile {
+		check(fmt.Errorf("--save-profile needs a --profile to save"), 2, "")
+	}
+	inputs := readBuildInputs()
+	libFailed := inputs.libPath != "" && !buildLib() //Only the commands that import lib fail for it
+	var names []string
+	var reasons [][]string
+	var upToDate int
+	for _, name := range getSourceList() {
 		if !strings.HasSuffix(name, ".go") {
 			continue
 		}
-		srcFilename = projectDir + "/src/" + name
-		binFilename = projectDir + "/bin/" + name[:len(name)-3] //removes .go from binary filename
-		if !compileBinary(srcFilename, binFilename) {
+		if onlyLibUsers && !usesLib(projectDir+"/src/"+name, inputs.libPath) {
+			continue
+		}
+		name = name[:len(name)-3] //removes .go from binary filename
+		stale := staleReasons(name, inputs)
+		if len(stale) == 0 && !force {
+			upToDate++
+			continue
+		}
+		if len(stale) == 0 {
+			stale = []string{"forced"}
+		}
+		names = append(names, name)
+		reasons = append(reasons, stale)
+	}
+	if len(names) == 0 {
+		fmt.Printf("All %d commands are up to date. Use --force to rebuild them anyway.\n", upToDate)
+		if libFailed {
 			os.Exit(1)
 		}
+		return
+	}
+
+	results := make([]recompileResult, len(names))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range max(jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				start := time.Now()
+				srcFilename := projectDir + "/src/" + names[i] + ".go"
+				if libFailed && usesLib(srcFilename, inputs.libPath) {
+					results[i] = recompileResult{Name: names[i], Reasons: reasons[i], Output: "lib failed to build"}
+					continue
+				}
+				unlock := lockCommand(names[i])
+				binFilename := projectDir + "/bin/" + names[i]
+				out, err := buildBinary(srcFilename, binFilename)
+				if err == nil && saveProfile {
+					err = saveProfileHeader(srcFilename, binFilename)
+				}
+				unlock()
+				results[i] = recompileResult{Name: names[i], Reasons: reasons[i], Duration: time.Since(start)}
+				if err != nil {
+					results[i].Output = strings.TrimSpace(out + "\n" + err.Error())
+				}
+			}
+		}()
+	}
+	for i := range names {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	//The full compiler output of each failure, ahead of the summary
+	for _, r := range results {
+		if r.Output != "" {
+			fmt.Fprintf(os.Stderr, "--- %s\n%s\n", r.Name, r.Output)
+		}
+	}
+
+	var failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND\tSTATUS\tTIME\tREASON\tERROR")
+	for _, r := range results {
+		status := "ok"
+		if r.Output != "" {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fs\t%s\t%s\n", r.Name, status, r.Duration.Seconds(), strings.Join(r.Reasons, ", "), firstErrorLine(r.Output))
+	}
+	w.Flush()
+	fmt.Printf("Recompiled %d of %d commands, %d failed, %d up to date\n", len(results)-failed, len(results), failed, upToDate)
+	if failed > 0 || libFailed {
+		os.Exit(1)
+	}
+}
+
+// The first line of compiler output that describes an error, skipping the "# package" header.
+func firstErrorLine(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
 	}
+	return ""
 }
 
 func compileBinary(srcFilename, binFilename string) bool {
-	cmd := exec.Command("go", "build", "-o", binFilename, srcFilename)
-	cmd.Dir = projectDir
+	out, err := buildBinary(srcFilename, binFilename)
+	return !check(err, 1, out)
+}
 
-	out, err := cmd.CombinedOutput()
+// Build the binary, running go get for any missing module the compiler names (then building again,
+// up to maxResolveRounds times, see dependencyResolution). Any extra env (e.g. GOOS=windows) is set after
+// the command's own build settings. The binary is built under a temporary name and renamed into place,
+// so a concurrent run never executes a partly written binary. Returns the compiler output, or a report
+// of the modules that couldn't be resolved, if the build fails. Safe for concurrent use.
+func buildBinary(srcFilename, binFilename string, extraEnv ...string) (string, error) {
+	return goBuild(srcFilename, binFilename, true, extraEnv...)
+}
+
+// Build the binary as buildBinary does. Missing modules are only fetched if resolveDeps is set.
+func goBuild(srcFilename, binFilename string, resolveDeps bool, extraEnv ...string) (string, error) {
+	src, err := os.ReadFile(srcFilename)
+	if err != nil {
+		return "", err
+	}
+	if preludePath := preludeImportPath(); preludePath != "" && bytes.Contains(src, []byte(strconv.Quote(preludePath))) {
+		writePrelude() //Build against the prelude of this goscript
+	}
+	settings, err := readBuildSettings(src)
 	if err != nil {
-		re := regexp.MustCompile(`go get (.+)`)
-		matches := re.FindAllSubmatch(out, -1)
-		if len(matches) > 0 {
-			for _, m := range matches {
-				pkg := strings.TrimSpace(string(m[1]))
-				goGet(pkg)
+		return "", err
+	}
+	tmpBin := binFilename
+	if binFilename != os.DevNull {
+		tmpBin = binFilename + "." + uniqueSuffix() + ".tmp"
+		defer os.Remove(tmpBin) //Left only if the build fails
+	}
+	args := append(append([]string{"build"}, settings.Flags...), "-o", tmpBin, srcFilename)
+
+	var deps dependencyResolution
+	for {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = projectDir
+		cmd.Env = append(append(os.Environ(), settings.Env...), extraEnv...)
+
+		unlock := lockProject(false) //go get waits for running builds, and builds wait for go get
+		out, err := cmd.CombinedOutput()
+		unlock()
+		if err == nil {
+			if tmpBin != binFilename {
+				err = os.Rename(tmpBin, binFilename)
 			}
-			compileBinary(srcFilename, binFilename)
-		} else {
-			if check(err, 1, string(out)) { //fmt.Sprintf("%v: %s\n", err, out)
-				return false
+			return "", err
+		}
+		if !resolveDeps || !deps.resolve(string(out)) {
+			if len(deps.attempts) == 0 {
+				return string(out), err //An ordinary compile error
 			}
+			return deps.report(string(out)), err
 		}
 	}
-	return true
 }
 
 func createNewProject(dir string) {
@@ -359,8 +652,8 @@ func createNewProject(dir string) {
 		fmt.Printf("  a. Create the project directory\n")
 		fmt.Printf("  b. Run go mod init <project>\n")
 		fmt.Printf("  c. Run 'go get github.com/bitfield/script'\n")
-		fmt.Printf("  d. Create 'src' and 'bin' subdirectories in the project\n")
-		fmt.Printf("  e. Add the required Go template file 'script.tmpl'\n")
+		fmt.Printf("  d. Create 'src', 'bin', 'lib' and 'templates' subdirectories in the project\n")
+		fmt.Printf("  e. Generate the standard library imports table 'stdimports.json' (see --refresh-imports)\n")
 		fmt.Printf("  f. Print out instructions to set GOSCRIPT_PROJECT_DIR and add GOSCRIPT_PROJECT_DIR/bin to the PATH\n")
 		return
 	}
@@ -390,19 +683,17 @@ func createNewProject(dir string) {
 	out, err = cmd.CombinedOutput()
 	check(err, 2, fmt.Sprintf("%v: %s\n", err, out))
 
-	//Create 'src' and 'bin' subdirectories
+	//Create 'src', 'bin', 'lib' and 'templates' subdirectories
 	srcDir := projectDir + "/src"
 	os.Mkdir(srcDir, 0766)
 	binDir := projectDir + "/bin"
 	os.Mkdir(binDir, 0766)
+	os.Mkdir(projectDir+"/templates", 0766)
+	createLib() //Helpers shared by the commands, imported as lib
+	writePrelude()
 
-	//Write script.tmpl file
-	// Open the file for writing, creates it if it doesn't exist, or truncates if it exists.
-	filename := projectDir + "/script.tmpl"
-	file, err := os.Create(filename)
-	check(err, 2, "")
-	defer file.Close()
-	file.WriteString("package main\n\nimport ( {{range .Imports}}\n\t{{.}}{{ end }}\n)\n\nfunc main() {\n\t{{.Code}}\n}\n")
+	//Generate the standard library imports table from the installed Go toolchain
+	refreshImports()
 
 	//Print instructions to set environment variable GOSCRIPT_PROJECT_DIR and add GOSCRIPT_PROJECT_DIR/bin to PATH
 	fmt.Printf("Created project %s at %s\n", projectName, projectDir)
@@ -411,17 +702,25 @@ func createNewProject(dir string) {
 	fmt.Printf("\t2. Add %s to your PATH environment variable.\n", binDir)
 }
 
-func cleanTemporaryFiles(name string) {
-	srcFilename := projectDir + "/src/" + name + ".go"
-	binFilename := projectDir + "/bin/" + name
-	if checkFileExists(srcFilename) {
-		err := os.Remove(srcFilename)
-		check(err, 1, "")
+// Parse a size such as 65536, 64K or 16M into a number of bytes.
+func parseSize(size string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(strings.ToUpper(size), "K"):
+		multiplier = 1024
+	case strings.HasSuffix(strings.ToUpper(size), "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(strings.ToUpper(size), "G"):
+		multiplier = 1024 * 1024 * 1024
 	}
-	if checkFileExists(binFilename) {
-		err := os.Remove(binFilename)
-		check(err, 1, "")
+	if multiplier > 1 {
+		size = size[:len(size)-1]
 	}
+	n, err := strconv.Atoi(size)
+	if err == nil && n <= 0 {
+		err = fmt.Errorf("size must be positive: %d", n)
+	}
+	return n * multiplier, err
 }
 
 func checkFileExists(filePath string) bool {
@@ -469,19 +768,46 @@ func main() {
 	var toCat string
 	var toExport string
 	var binToExport string
+	var buildFor string
+	var outDir string
 	var toDelete string
 	var toRestore string
 	var code string
 	var inputFile string
 	var listCommands bool
 	var recompile bool
+	var usesLibOnly bool
+	var jobs int
+	var force bool
+	var saveProfile bool
+	var listStale bool
 	var setupProject string
 	var toGoGet string
+	var doRefreshImports bool
+	var doReindex bool
+	var aliasOp string
+	var doLearnImports bool
 	var doTidy bool
 	var path string
 	var printDir bool
 	var printTemplate bool
+	var tmplName string
+	var doTemplateDiff bool
+	var doTemplateReset bool
+	vars := templateVars{}
+	var dotPrelude bool
+	var cacheOp string
+	var keepSource bool
+	var evalExpr string
+	var evalFormat string
+	var eachLine bool
+	var printLines bool
+	var beginCode string
+	var endCode string
+	var fieldSep string
+	var maxLineSize string
 	var execCode bool
+	var doFixImports bool
 	var printShebang bool
 	var printVersion bool
 
@@ -489,12 +815,23 @@ func main() {
 	flag.StringVar(&name, "n", "", "A name for your command.")
 	flag.StringVar(&toCat, "cat", "", "Prints the script, or copies it to --name if provided. The original source and binary remain in the project.")
 	flag.StringVar(&toExport, "export", "", "Exports the named script to stdout with shebang added and removes source and binary from project.")
+	flag.StringVar(&buildFor, "build-for", "", "Cross-compile the command named by the argument for each os/arch target, e.g. --build-for linux/arm64,windows/amd64 <name>.")
+	flag.StringVar(&outDir, "out", ".", "With --build-for, the directory to write the binaries and SHA256SUMS to. Defaults to the current directory.")
 	flag.StringVar(&binToExport, "export-bin", "", "Exports the named binary to local directory and removes source and binary from project.")
 	flag.StringVar(&toEdit, "edit", "", "Edit the named command in the editor specified by environment variable GOSCRIPT_EDITOR or EDITOR.")
 	flag.StringVar(&toEdit, "e", "", "Edit the named command in the editor specified by environment variable GOSCRIPT_EDITOR or EDITOR.")
 	flag.StringVar(&code, "code", "", "The code of your command. Defaults to empty string.")
 	flag.StringVar(&code, "c", "", "The code of your command. Defaults to empty string.")
 
+	flag.StringVar(&evalExpr, "eval", "", "A Go expression to evaluate. Prints each value it returns.")
+	flag.StringVar(&evalFormat, "format", "v", "With --eval, how to print each result: v (%v), +v (%+v), #v (%#v) or json.")
+	flag.BoolVar(&eachLine, "lines", false, "Run the code for each line of stdin, with line, fields and NR defined (like awk or perl -n).")
+	flag.BoolVar(&printLines, "print-lines", false, "Like --lines, but print each (possibly modified) line after the code runs (like perl -p).")
+	flag.StringVar(&beginCode, "begin", "", "With --lines or --print-lines, code to run before the first line (like awk BEGIN).")
+	flag.StringVar(&endCode, "end", "", "With --lines or --print-lines, code to run after the last line (like awk END).")
+	flag.StringVar(&fieldSep, "fs", "", "With --lines or --print-lines, the separator used to split line into fields. Defaults to whitespace.")
+	flag.StringVar(&maxLineSize, "bufsize", "1M", "With --lines or --print-lines, the longest line accepted (e.g. 64K, 1M, 16M).")
+
 	flag.StringVar(&inputFile, "file", "", "A go src file, complete with main function and imports. Alternative to --code and --imports options.")
 	flag.StringVar(&inputFile, "f", "", "A go src file, complete with main function and imports. Alternative to --code and --imports options.")
 	flag.StringVar(&toDelete, "delete", "", "Delete the specified compiled command. Removes .go extension from source file so it can be restored.")
@@ -504,8 +841,13 @@ func main() {
 	flag.StringVar(&path, "p", "", "Print the path to the source file specified, if exists in the project. Blank if not found.")
 	flag.BoolVar(&printDir, "dir", false, "Print the directory path to the project.")
 	flag.BoolVar(&printDir, "d", false, "Print the directory path to the project.")
-	flag.BoolVar(&printTemplate, "template", false, "Print a template go source file to stdout. After edits, use --file to compile with goscript.")
-	flag.BoolVar(&printTemplate, "t", false, "Print a template go source file to stdout. After edits, use --file to compile with goscript.")
+	flag.BoolVar(&printTemplate, "template", false, "Print a template go source file to stdout, or list the templates if no --tmpl, --code or --name. After edits, use --file to compile with goscript.")
+	flag.BoolVar(&doTemplateDiff, "template-diff", false, "Show how the project copy of the template (script.tmpl or --tmpl) differs from the embedded one.")
+	flag.BoolVar(&doTemplateReset, "template-reset", false, "Restore the project copy of the template (script.tmpl or --tmpl) to the embedded one.")
+	flag.Var(vars, "D", "Set a template variable, available to templates as {{.Vars.name}}. Format name=value. Repeat for more variables.")
+	flag.BoolVar(&dotPrelude, "prelude", false, "Dot-import the goscript prelude, so its helpers can be called as Die, Must, Args, Env, Confirm, Sh and Log.")
+	flag.StringVar(&tmplName, "tmpl", "", "The name of the template to wrap --code with (<project>/templates/<name>.tmpl). Defaults to script.tmpl.")
+	flag.BoolVar(&printTemplate, "t", false, "Print a template go source file to stdout, or list the templates if no --tmpl, --code or --name. After edits, use --file to compile with goscript.")
 
 	flag.BoolVar(&printShebang, "bang", false, "Print the expected shebang line.")
 	flag.BoolVar(&printShebang, "b", false, "Print the expected shebang line.")
@@ -514,11 +856,25 @@ func main() {
 	flag.BoolVar(&listCommands, "l", false, "Print the list of existing commands.")
 
 	flag.StringVar(&setupProject, "setup", "", "A name or absolute path. Creates a module project to be used by goscript. If no name is given, prints setup instructions.")
-	flag.BoolVar(&recompile, "recompile", false, "Recompile all existing source files in the project src directory.")
+	flag.BoolVar(&usesLibOnly, "uses-lib", false, "With --list or --recompile, only the commands that import the project helper library (lib).")
+	flag.StringVar(&cacheOp, "cache", "", "Manage the build cache of ad-hoc snippets and shebang scripts: clean (enforce the size and age limits), clear or info.")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "With --recompile, the number of commands to build in parallel. Defaults to the number of CPUs.")
+	flag.StringVar(&buildProfile, "profile", "", "Build with a named profile: debug, release or a [profile <name>] section of goscript.conf. Saved in the header of named commands.")
+	flag.BoolVar(&saveProfile, "save-profile", false, "With --recompile --profile, save the profile in the header of each command that builds, so later rebuilds keep it.")
+	flag.BoolVar(&recompile, "recompile", false, "Recompile the existing commands in the project src directory whose binaries are out of date.")
+	flag.BoolVar(&force, "force", false, "With --recompile, rebuild every command, including those that are up to date.")
+	flag.BoolVar(&listStale, "stale", false, "List the commands --recompile would rebuild, and why.")
 	flag.StringVar(&toGoGet, "goget", "", "Go get an external package (not part of stdlib) to pull into the project.")
 	flag.StringVar(&toGoGet, "g", "", "Go get an external package (not part of stdlib) to pull into the project.")
 	flag.BoolVar(&doTidy, "gotidy", false, "Run go mod tidy (remove modules from go.mod file that are no longer required.)")
+	flag.BoolVar(&doRefreshImports, "refresh-imports", false, "Regenerate the standard library imports table (stdimports.json) from the installed Go toolchain.")
+	flag.StringVar(&aliasOp, "alias", "", "Manage import aliases in imports.json: add <alias>=<importpath>, rm <alias>, list or which <alias>.")
+	flag.BoolVar(&doLearnImports, "learn-imports", false, "Collect the import aliases used by the project sources and offer to merge new ones into imports.json.")
+	flag.BoolVar(&doReindex, "reindex", false, "Rebuild the index of packages in modules required by go.mod or present in the module cache.")
+
+	flag.BoolVar(&doFixImports, "fix-imports", false, "With --file or --name, add missing imports and remove unused ones before compiling.")
 
+	flag.BoolVar(&keepSource, "keep", false, "Save a copy of the generated source file in the temp directory for inspection.")
 	flag.BoolVar(&execCode, "exec", false, "Execute the resulting binary.")
 	flag.BoolVar(&execCode, "x", false, "Execute the resulting binary.")
 
@@ -531,21 +887,49 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "Options:")
 		fmt.Fprintln(os.Stderr, "  --code|-c string\n\tThe code of your command or the name of a file containing the body of the main function.")
+		fmt.Fprintln(os.Stderr, "  --eval string\n\tA Go expression to evaluate and print, e.g. 'time.Now().Add(90*time.Minute)'. Prints each value it returns.")
+		fmt.Fprintln(os.Stderr, "  --format string\n\tWith --eval, how to print each result: v, +v or #v (as the fmt verbs) or json. Defaults to v.")
+		fmt.Fprintln(os.Stderr, "  --lines\n\tRun the code for each line of stdin, with line, fields and NR defined (like awk or perl -n).")
+		fmt.Fprintln(os.Stderr, "  --print-lines\n\tLike --lines, but print each (possibly modified) line after the code runs (like perl -p).")
+		fmt.Fprintln(os.Stderr, "  --begin string\n\tWith --lines or --print-lines, code to run before the first line (like awk BEGIN).")
+		fmt.Fprintln(os.Stderr, "  --end string\n\tWith --lines or --print-lines, code to run after the last line (like awk END).")
+		fmt.Fprintln(os.Stderr, "  --fs string\n\tWith --lines or --print-lines, the separator used to split line into fields. Defaults to whitespace.")
+		fmt.Fprintln(os.Stderr, "  --bufsize string\n\tWith --lines or --print-lines, the longest line accepted (e.g. 64K, 1M, 16M). Defaults to 1M.")
 		fmt.Fprintln(os.Stderr, "  --file|-f string\n\tA go src file, complete with main function and imports. Alternative to --code.")
+		fmt.Fprintln(os.Stderr, "  --fix-imports\n\tWith --file or --name, add missing imports and remove unused ones before compiling (goimports style).")
 		fmt.Fprintln(os.Stderr, "  --exec|-x\n\tExecute the resulting binary.")
+		fmt.Fprintln(os.Stderr, "  --keep\n\tSave a copy of the generated source file in the temp directory for inspection (e.g. when it fails to compile).")
 		fmt.Fprintln(os.Stderr, "  --name|-n string\n\tA name for your command. The code will be saved to the project src directory with that name.")
 		fmt.Fprintln(os.Stderr, "  --edit|-e string\n\tEdit the named command in the editor specified by environment variable GOSCRIPT_EDITOR or EDITOR.")
-		fmt.Fprintln(os.Stderr, "  --template|-t\n\tPrint a template go source file to stdout, or to the project src directory if --name provided.")
+		fmt.Fprintln(os.Stderr, "  --template|-t\n\tPrint a template go source file to stdout, or to the project src directory if --name provided.\n\tWith no --tmpl, --code or --name, list the available templates.")
+		fmt.Fprintln(os.Stderr, "  --tmpl string\n\tThe name of the template to use with --code or --template (<project>/templates/<name>.tmpl). Defaults to script.tmpl.")
+		fmt.Fprintln(os.Stderr, "  --prelude\n\tDot-import the goscript prelude, so its helpers can be called as Die, Must, Args, Env, Confirm, Sh and Log.\n\tSet prelude = dot in the [vars] section of goscript.conf to make it the default.")
+		fmt.Fprintln(os.Stderr, "  -D name=value\n\tSet a template variable, available to templates as {{.Vars.name}}. Repeat for more variables.\n\tDefaults can be set in the [vars] section of goscript.conf.")
+		fmt.Fprintln(os.Stderr, "  --template-diff\n\tShow how the project copy of the template (script.tmpl or --tmpl) differs from the embedded one.")
+		fmt.Fprintln(os.Stderr, "  --template-reset\n\tRestore the project copy of the template (script.tmpl or --tmpl) to the embedded one.")
 		fmt.Fprintln(os.Stderr, "  --list|-l\n\tPrint the list of existing commands.")
 		fmt.Fprintln(os.Stderr, "  --path|-p string\n\tPrint the path to the source file specified, if exists in the project. Blank if not found.")
 		fmt.Fprintln(os.Stderr, "  --cat string\n\tPrints the script, or copies it to --name if provided. The original source and binary remain in the project.")
 		fmt.Fprintln(os.Stderr, "  --export string\n\tExports the named script to stdout with shebang added and removes source and binary from project.")
 		fmt.Fprintln(os.Stderr, "  --export-bin string\n\tExports the named binary to the local directory and removes source and binary from project.")
+		fmt.Fprintln(os.Stderr, "  --build-for <os>/<arch>[,<os>/<arch>...] <name>\n\tCross-compile the named command for each target into <name>_<os>_<arch> files with a SHA256SUMS file.\n\tThe command stays in the project.")
+		fmt.Fprintln(os.Stderr, "  --out string\n\tWith --build-for, the directory to write the binaries and SHA256SUMS to. Defaults to the current directory.")
 		fmt.Fprintln(os.Stderr, "  --delete string\n\tDelete the specified compiled command. Removes .go extension from source file so it remains recoverable.")
 		fmt.Fprintln(os.Stderr, "  --restore string\n\tRestore a command after delete or export operation. Restores .go extension to the source file and recompiles.")
 		fmt.Fprintln(os.Stderr, "  --goget|-g string\n\tGo get an external package (not part of stdlib) to pull into the project.")
 		fmt.Fprintln(os.Stderr, "  --gotidy\n\tRun go mod tidy (remove modules from go.mod file that are no longer required.")
-		fmt.Fprintln(os.Stderr, "  --recompile\n\tRecompile existing source files in the project src directory.")
+		fmt.Fprintln(os.Stderr, "  --alias add <alias>=<importpath>|rm <alias>|list|which <alias>\n\tManage import aliases in imports.json. Shows the source (built-in, generated, project or user) of each alias.")
+		fmt.Fprintln(os.Stderr, "  --learn-imports\n\tCollect the import aliases used by the project sources and offer to merge new ones into imports.json.")
+		fmt.Fprintln(os.Stderr, "  --refresh-imports\n\tRegenerate the standard library imports table (stdimports.json) from the installed Go toolchain.")
+		fmt.Fprintln(os.Stderr, "  --reindex\n\tRebuild the index of packages in modules required by go.mod or present in the module cache.")
+		fmt.Fprintln(os.Stderr, "  --cache clean|clear|info\n\tManage the build cache of ad-hoc snippets and shebang scripts. clean enforces the size and age limits\n\tset in the [cache] section of goscript.conf, clear removes every cached binary and info shows the usage.")
+		fmt.Fprintln(os.Stderr, "  --profile string\n\tBuild with a named profile: debug, release or a [profile <name>] section of goscript.conf.\n\tSaved in the header of named commands, so --recompile keeps it. With --recompile, rebuilds every command saved with another profile\n\twith this one, for this run only.")
+		fmt.Fprintln(os.Stderr, "  --save-profile\n\tWith --recompile --profile, save the profile in the header of each command that builds, so later rebuilds keep it.")
+		fmt.Fprintln(os.Stderr, "  --recompile\n\tRecompile the existing commands whose binaries are out of date (see --stale).")
+		fmt.Fprintln(os.Stderr, "  --force\n\tWith --recompile, rebuild every command, including those that are up to date.")
+		fmt.Fprintln(os.Stderr, "  --stale\n\tList the commands --recompile would rebuild, and why (source, go.mod, go.sum, goscript.conf,\n\ttemplate, lib or Go version changed since the binary was built).")
+		fmt.Fprintln(os.Stderr, "  --jobs int\n\tWith --recompile, the number of commands to build in parallel. Defaults to the number of CPUs.")
+		fmt.Fprintln(os.Stderr, "  --uses-lib\n\tWith --list or --recompile, only the commands that import the project helper library (lib).")
 		fmt.Fprintln(os.Stderr, "  --setup\n\tA name, absolute path or 'help'. Creates a module project to be used by goscript. If 'help', prints setup instructions.")
 		fmt.Fprintln(os.Stderr, "  --dir|-d\n\tPrint the directory path to the project.")
 		fmt.Fprintln(os.Stderr, "  --bang|-b\n\tPrint the expected shebang line.")
@@ -556,7 +940,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s --exec --code 'script.Echo(\"Hello World!\\n\").Stdout()'\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "\nExample shebang in 'myscript.go' file:")
 		fmt.Fprintf(os.Stderr, "  (1) Add '#!/usr/bin/env -S %s' to the top of your go source file.\n", os.Args[0])
-		fmt.Fprintln(os.Stderr, "  (2) Set execute permission and type \"./myscript.go\" as you would with a shell script.\n")
+		fmt.Fprintln(os.Stderr, "  (2) Set execute permission and type \"./myscript.go\" as you would with a shell script.")
+		fmt.Fprintln(os.Stderr)
 	}
 
 	//Shebang scenarios (Note any of these could also be straight commandline and not shebang):
@@ -629,11 +1014,21 @@ func main() {
 	//--list: List existing commands
 	if listCommands {
 		cmds := getSourceList() //Assumes binary list is same. Not true if template files that were never compiled, but should be rare.
+		libPath := libImportPath()
 		for _, cmd := range cmds {
+			if usesLibOnly && !usesLib(projectDir+"/src/"+cmd, libPath) {
+				continue
+			}
 			if !strings.HasSuffix(cmd, ".go") {
 				fmt.Printf("%s (requires --restore)\n", cmd)
 				continue
 			}
+			//Note the template that produced the command, if not the default
+			src, _ := os.ReadFile(projectDir + "/src/" + cmd)
+			if t := readTemplateHeader(string(src)); t != "" && t != defaultTemplate {
+				fmt.Printf("%s (template: %s)\n", cmd[:len(cmd)-3], t)
+				continue
+			}
 			fmt.Printf("%s\n", cmd[:len(cmd)-3]) //Remove the .go extension.
 		}
 		return //Exit the program after printing the list of commands
@@ -641,28 +1036,113 @@ func main() {
 
 	//--goget: Execute a go get <pkg> to bring external package into project
 	if toGoGet != "" {
-		goGet(toGoGet)
+		pkgs, err := goGet(toGoGet)
+		check(err, 2, "")
+		for _, pkg := range pkgs {
+			fmt.Printf("%s -> %s\n", pkg.Name, pkg.ImportPath)
+		}
 		return //Exit after go get package
 	}
 
 	//--gotidy: Execute a go mod tidy to cleanup modules no longer required.
 	if doTidy {
-		goTidy()
+		check(goTidy(), 2, "")
 		return //Exit after go mod tidy
 	}
 
+	//--alias: Add, remove, list or look up import aliases
+	if aliasOp != "" {
+		manageAliases(aliasOp, flag.Args())
+		return //Exit after managing aliases
+	}
+
+	//--learn-imports: Learn import aliases from the project sources
+	if doLearnImports {
+		learnImports()
+		return //Exit after learning imports
+	}
+
+	//--refresh-imports: Regenerate the standard library imports table from the installed Go toolchain
+	if doRefreshImports {
+		refreshImports()
+		return //Exit after generating the imports table
+	}
+
+	//--reindex: Rebuild the offline package index from go.mod requirements and the module cache
+	if doReindex {
+		buildPackageIndex()
+		return //Exit after rebuilding the index
+	}
+
+	//--cache: Manage the build cache
+	if cacheOp != "" {
+		manageCache(cacheOp)
+		return //Exit the program after managing the cache
+	}
+
+	//--stale: List the commands that are out of date
+	if listStale {
+		listStaleCommands()
+		return //Exit the program after listing
+	}
+
 	//--recompile: Recompile existing sources
 	if recompile {
-		recompileCommands()
+		recompileCommands(usesLibOnly, force, saveProfile, jobs)
 		return //Exit the program after recompiling existing commands
 	}
 
+	//--lines and --print-lines: Wrap the code in a loop over the lines of stdin
+	repl := Repl{Code: code, Vars: loadTemplateVars(vars)}
+	repl.Prelude = dotPrelude || repl.Vars["prelude"] == "dot"
+	lineMode := eachLine || printLines
+	if lineMode {
+		if tmplName == "" {
+			tmplName = "lines"
+		}
+		size, err := parseSize(maxLineSize)
+		check(err, 2, "Invalid --bufsize")
+		repl.Begin, repl.End, repl.FieldSep, repl.PrintLines, repl.MaxLineSize = beginCode, endCode, fieldSep, printLines, size
+	}
+
+	//--template-diff: Compare the project override of a template with the embedded one
+	if doTemplateDiff {
+		diffTemplate(tmplName)
+		return //Exit the program after printing the diff
+	}
+
+	//--template-reset: Restore the project copy of a template to the embedded one
+	if doTemplateReset {
+		resetTemplate(tmplName)
+		return //Exit the program after restoring the template
+	}
+
+	//--eval: Evaluate an expression and print the result(s)
+	if evalExpr != "" {
+		if !slices.Contains([]string{"v", "+v", "#v", "json"}, evalFormat) {
+			check(fmt.Errorf("Unknown --format %q. Use v, +v, #v or json.", evalFormat), 2, "")
+		}
+		if tmplName == "" {
+			tmplName = "eval"
+		}
+		repl.Code, repl.Format = evalExpr, evalFormat
+		execCode = execCode || name == "" //Evaluating without a name only makes sense if the result is printed
+	}
+
 	//--template: Print an empty template to give a starting point when creating a new source code file
 	if printTemplate {
-		buf = assembleSourceFile(code)
+		if tmplName == "" && repl.Code == "" && name == "" && !lineMode {
+			for _, t := range getTemplateList() {
+				fmt.Println(t)
+			}
+			return //Exit the program after listing the templates
+		}
+		buf = assembleSourceFile(repl, tmplName)
 		if name != "" {
 			srcFilename := projectDir + "/src/" + name + ".go"
+			unlock := lockCommand(name)
 			writeSourceFile(srcFilename, buf)
+			unlock()
 			fmt.Printf("Source file written to: %s\n", srcFilename)
 			return
 		} else {
@@ -685,6 +1165,8 @@ func main() {
 		buf = readSourceFile(srcFilename)
 		if name != "" {
 			copy := projectDir + "/src/" + name + ".go"
+			unlock := lockCommand(name)
+			defer unlock()
 			if writeSourceFile(copy, buf) {
 				fmt.Printf("A copy of %s was saved as %s\n", toCat, name)
 			}
@@ -717,6 +1199,12 @@ func main() {
 		return //Exit the program after exporting
 	}
 
+	//--build-for: Cross-compile the named command for other platforms. The command stays in the project.
+	if buildFor != "" {
+		buildForTargets(buildFor, flag.Args(), outDir)
+		return //Exit the program after building
+	}
+
 	//--delete: Deletes the named binary. Renames the named source file without .go extension so it remains recoverable.
 	if toDelete != "" {
 		deleteCommand(toDelete)
@@ -730,47 +1218,120 @@ func main() {
 	}
 
 	//--file: Handle a regular go source file (potentially with a shebang (#!) at the top)
+	var snippet *Repl   //The code, if the source was generated from --code
+	var lineFile string //The --file that positions in the source refer to (see addLineDirective)
 	if inputFile != "" {
 		buf = readSourceFile(inputFile)
-		//--code: Handle typical one-liner code specified on command line
-	} else if code != "" {
-		buf = assembleSourceFile(code)
+		if name == "" {
+			lineFile = inputFile //Errors and panics point at the user's file, not the scratch main.go
+		}
+		if doFixImports {
+			buf = fixImports(buf, lineFile)
+		}
+		if lineFile != "" {
+			buf = addLineDirective(buf, lineFile)
+		}
+		//--code: Handle typical one-liner code specified on command line (optionally in a loop over lines with --lines)
+	} else if repl.Code != "" || lineMode {
+		buf = assembleSourceFile(repl, tmplName)
+		snippet = &repl
 		//--name: Handle compiling a pre-existing source file located in the project/src folder
 	} else if name != "" {
 		srcFilename := projectDir + "/src/" + name + ".go"
 		buf = readSourceFile(srcFilename)
+		if doFixImports {
+			buf = fixImports(buf, "") //The fixed source is written back to the project src directory below
+		}
 		//(no options): Print usage and exit
 	} else {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	//Temporary name needed to save source and compile binary
-	var isTemporary bool
-	if name == "" {
-		name = fmt.Sprintf("gocmd-%d", time.Now().UnixNano()) //temporary name, not for user. Will be deleted after exec.
-		isTemporary = true
+	//--profile: Record the build profile in the source, so a named command keeps it (e.g. for --recompile)
+	if buildProfile != "" {
+		buf = setHeaderDirective(buf, "profile", buildProfile)
 	}
+
+	//Code without a name is built in a scratch directory, removed after exec (see createScratchDir)
+	var scratchDir, cachedBin string
+	var cacheHit bool
 	srcFilename := projectDir + "/src/" + name + ".go"
 	binFilename := projectDir + "/bin/" + name
+	unlockCommand := func() {}
+	if name == "" {
+		if execCode {
+			cachedBin = cachedBinaryPath(buf.Bytes()) //Ad-hoc snippets and shebang scripts are run from the build cache
+			cacheHit = cachedBin != "" && isCached(cachedBin)
+		}
+		if !cacheHit {
+			scratchDir = createScratchDir()
+			srcFilename = scratchDir + "/main.go" //Never the user's file name, which may carry build constraints (e.g. _test.go)
+			binFilename = scratchDir + "/gocmd"
+			if runtime.GOOS == "windows" {
+				binFilename += ".exe"
+			}
+		}
+	} else {
+		unlockCommand = lockCommand(name) //Another goscript may be writing the same command
+	}
+	removeScratchDir := func() {
+		if scratchDir != "" {
+			os.RemoveAll(scratchDir)
+		}
+	}
 
-	writeSourceFile(srcFilename, buf)
-	if !compileBinary(srcFilename, binFilename) {
-		if isTemporary {
-			cleanTemporaryFiles(name)
+	//--keep: Save a copy of the generated source outside the project for inspection
+	if keepSource {
+		keptName := name
+		if keptName == "" {
+			keptName = "gocmd-" + uniqueSuffix()
+		}
+		keptFilename := filepath.Join(os.TempDir(), keptName+".go")
+		writeSourceFile(keptFilename, buf)
+		fmt.Fprintf(os.Stderr, "Generated source kept at %s\n", keptFilename)
+	}
+
+	//Report a failed build. Errors in a snippet are mapped back to the lines of the user's code.
+	reportBuildErrors := func(out string, err error) {
+		if snippet != nil {
+			reportSnippetErrors(out, err, *snippet, tmplName)
+		} else if lineFile != "" {
+			check(err, 1, showUserFilename(out, lineFile))
+		} else {
+			check(err, 1, out)
 		}
-		os.Exit(1)
 	}
 
+	if cacheHit {
+		binFilename = cachedBin //Nothing to compile
+	} else if cachedBin != "" {
+		writeSourceFile(srcFilename, buf)
+		if out, err := compileCachedBinary(srcFilename, cachedBin); err != nil {
+			reportBuildErrors(out, err)
+			removeScratchDir()
+			os.Exit(1)
+		}
+		binFilename = cachedBin
+		removeScratchDir()
+		pruneCache()
+	} else {
+		writeSourceFile(srcFilename, buf)
+		if out, err := buildBinary(srcFilename, binFilename); err != nil {
+			reportBuildErrors(out, err)
+			removeScratchDir()
+			os.Exit(1)
+		}
+	}
+	unlockCommand() //Written and built. Running the command doesn't need the lock.
+
 	if execCode {
 
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		go func() {
 			<-c
-			if isTemporary {
-				cleanTemporaryFiles(name)
-			}
+			removeScratchDir()
 			os.Exit(1)
 		}()
 
@@ -782,18 +1343,12 @@ func main() {
 		err := cmd.Start()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			if isTemporary {
-				cleanTemporaryFiles(name)
-			}
+			removeScratchDir()
 			os.Exit(1)
 		}
 		cmd.Wait()
-		if isTemporary {
-			cleanTemporaryFiles(name)
-		}
+		removeScratchDir()
 		os.Exit(cmd.ProcessState.ExitCode())
 	}
-	if isTemporary {
-		cleanTemporaryFiles(name)
-	}
+	removeScratchDir()
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Where a module in the package index came from. Lower ranks are suggested first.
+const (
+	rankDirect   = iota //required directly in the project go.mod
+	rankIndirect        //required indirectly (// indirect) in the project go.mod
+	rankModCache        //present in GOMODCACHE but not required by the project
+)
+
+// An importable package found in a module, keyed in the index by its package name.
+type indexedPackage struct {
+	ImportPath string
+	Module     string
+	Version    string
+	Rank       int
+}
+
+// A module to be scanned for packages.
+type indexedModule struct {
+	Path    string
+	Version string
+	Dir     string
+	Rank    int
+}
+
+// The packages resolved from the module cache (rankModCache) by this run, import path -> import path@version.
+// When the build asks for one, that version is fetched rather than the latest, so it works offline.
+var indexedVersions = make(map[string]string)
+
+// Rebuild the index if it is older than a day, since the module cache grows independently of go.mod.
+const pkgIndexMaxAge = 24 * time.Hour
+
+// Look up the packages named name in the offline package index, ranked best first.
+// Walking the module cache can take a while, so the index is only rebuilt when the name isn't
+// in it and the index is missing or stale (see isPackageIndexStale). --reindex rebuilds it on demand.
+func lookupPackageIndex(name string) []indexedPackage {
+	if pkgs := readPackageIndex()[name]; len(pkgs) > 0 || !isPackageIndexStale() {
+		return pkgs
+	}
+	buildPackageIndex()
+	return readPackageIndex()[name]
+}
+
+// Report whether the package index is missing, older than go.mod or older than pkgIndexMaxAge.
+func isPackageIndexStale() bool {
+	fileInfo, err := os.Stat(projectDir + "/pkgindex.json")
+	if err != nil {
+		return true
+	}
+	goMod, err := os.Stat(projectDir + "/go.mod")
+	return (err == nil && goMod.ModTime().After(fileInfo.ModTime())) || time.Since(fileInfo.ModTime()) > pkgIndexMaxAge
+}
+
+// Build the index of package name -> import paths from the modules required in the project go.mod
+// and the modules already downloaded to GOMODCACHE, then write it to <project>/pkgindex.json.
+// Everything is read from disk, so this works offline.
+func buildPackageIndex() {
+	cmd := exec.Command("go", "env", "GOMODCACHE")
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	check(err, 2, fmt.Sprintf("Unable to locate the module cache.\n%s", out))
+	modCache := strings.TrimSpace(string(out))
+
+	modules := make(map[string]indexedModule)
+	for _, m := range scanModuleCache(modCache) {
+		if existing, found := modules[m.Path]; !found || compareVersions(m.Version, existing.Version) > 0 {
+			modules[m.Path] = m
+		}
+	}
+	//Modules required by go.mod take precedence over whatever versions happen to be in the cache
+	for _, m := range readRequirements(modCache) {
+		modules[m.Path] = m
+	}
+
+	index := make(map[string][]indexedPackage)
+	for _, m := range modules {
+		for importPath, name := range scanModulePackages(m) {
+			index[name] = append(index[name], indexedPackage{ImportPath: importPath, Module: m.Path, Version: m.Version, Rank: m.Rank})
+		}
+	}
+	for _, pkgs := range index {
+		slices.SortFunc(pkgs, func(a, b indexedPackage) int {
+			if a.Rank != b.Rank {
+				return a.Rank - b.Rank
+			}
+			return compareCandidates("", a.ImportPath, b.ImportPath)
+		})
+	}
+
+	jsonData, err := json.MarshalIndent(index, "", "    ")
+	check(err, 2, "Unable to marshal content for pkgindex.json file.")
+	err = writeFileAtomic(projectDir+"/pkgindex.json", jsonData, 0644)
+	check(err, 2, "")
+}
+
+func readPackageIndex() map[string][]indexedPackage {
+	var index map[string][]indexedPackage
+	filename := projectDir + "/pkgindex.json"
+	if checkFileExists(filename) {
+		file, err := os.Open(filename)
+		check(err, 2, "")
+		defer file.Close()
+
+		byteValue, _ := io.ReadAll(file)
+		json.Unmarshal(byteValue, &index)
+	}
+	return index
+}
+
+// Read the requirements from the project go.mod ('go mod edit -json' does not touch the network).
+func readRequirements(modCache string) []indexedModule {
+	cmd := exec.Command("go", "mod", "edit", "-json")
+	cmd.Dir = projectDir
+	out, err := cmd.Output()
+	if check(err, 1, "Unable to read requirements from go.mod") {
+		return nil
+	}
+	var goMod struct {
+		Require []struct {
+			Path     string
+			Version  string
+			Indirect bool
+		}
+	}
+	json.Unmarshal(out, &goMod)
+
+	var modules []indexedModule
+	for _, r := range goMod.Require {
+		rank := rankDirect
+		if r.Indirect {
+			rank = rankIndirect
+		}
+		dir := filepath.Join(modCache, escapeModulePath(r.Path)+"@"+escapeModulePath(r.Version))
+		modules = append(modules, indexedModule{Path: r.Path, Version: r.Version, Dir: dir, Rank: rank})
+	}
+	return modules
+}
+
+// Find every module version extracted in the module cache. Module roots are the directories
+// named <escaped path>@<version>. The cache/ directory only holds downloads and is skipped.
+func scanModuleCache(modCache string) []indexedModule {
+	var modules []indexedModule
+	filepath.WalkDir(modCache, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == modCache {
+			return nil
+		}
+		rel, _ := filepath.Rel(modCache, path)
+		if rel == "cache" {
+			return filepath.SkipDir
+		}
+		if modPath, version, found := strings.Cut(filepath.ToSlash(rel), "@"); found {
+			modules = append(modules, indexedModule{Path: unescapeModulePath(modPath), Version: version, Dir: path, Rank: rankModCache})
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return modules
+}
+
+// Return importable package path -> package name for every package in the module. Internal,
+// testdata and vendor trees, hidden directories, nested modules and main packages are skipped.
+func scanModulePackages(m indexedModule) map[string]string {
+	pkgs := make(map[string]string)
+	filepath.WalkDir(m.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != m.Dir {
+			name := d.Name()
+			if name == "internal" || name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if checkFileExists(filepath.Join(path, "go.mod")) {
+				return filepath.SkipDir
+			}
+		}
+		if name := readPackageName(path); name != "" && name != "main" {
+			rel, _ := filepath.Rel(m.Dir, path)
+			pkgs[strings.TrimSuffix(m.Path+"/"+filepath.ToSlash(rel), "/.")] = name
+		}
+		return nil
+	})
+	return pkgs
+}
+
+// Return the package clause of the first non-test Go file in dir, or "" if there is none.
+func readPackageName(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil && file.Name.Name != "documentation" {
+			return file.Name.Name
+		}
+	}
+	return ""
+}
+
+// Print ranked suggestions when a package name matches more than one indexed package.
+func suggestPackages(name string, pkgs []indexedPackage) {
+	fmt.Fprintf(os.Stderr, "Package %s was not imported automatically. It matches several packages:\n", name)
+	for i, pkg := range pkgs {
+		var source string
+		switch pkg.Rank {
+		case rankDirect:
+			source = "required in go.mod"
+		case rankIndirect:
+			source = "required in go.mod, indirect"
+		default:
+			source = "module cache"
+		}
+		fmt.Fprintf(os.Stderr, "  %d. %s (%s %s, %s)\n", i+1, pkg.ImportPath, pkg.Module, pkg.Version, source)
+	}
+	fmt.Fprintf(os.Stderr, "Add the one you want to %s to import it automatically.\n", projectDir+"/imports.json")
+}
+
+// The module cache escapes upper case letters as '!' followed by the lower case letter.
+func escapeModulePath(path string) string {
+	var sb strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			sb.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func unescapeModulePath(path string) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range path {
+		if r == '!' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Compare two module versions (vMAJOR.MINOR.PATCH[-pre][+build]) numerically. A release sorts
+// after its pre-releases. Unparseable parts compare as strings.
+func compareVersions(a, b string) int {
+	coreA, preA, _ := strings.Cut(strings.SplitN(strings.TrimPrefix(a, "v"), "+", 2)[0], "-")
+	coreB, preB, _ := strings.Cut(strings.SplitN(strings.TrimPrefix(b, "v"), "+", 2)[0], "-")
+	partsA, partsB := strings.Split(coreA, "."), strings.Split(coreB, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numA, errA := strconv.Atoi(partsA[i])
+		numB, errB := strconv.Atoi(partsB[i])
+		if errA != nil || errB != nil {
+			if c := strings.Compare(partsA[i], partsB[i]); c != 0 {
+				return c
+			}
+		} else if numA != numB {
+			return numA - numB
+		}
+	}
+	if len(partsA) != len(partsB) {
+		return len(partsA) - len(partsB)
+	}
+	if preA == "" || preB == "" {
+		return len(preB) - len(preA) //No pre-release sorts last
+	}
+	return strings.Compare(preA, preB)
+}
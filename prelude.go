@@ -0,0 +1,94 @@
+package main
+
+import (
+	_ "embed"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+)
+
+// The alias (and package name) of the prelude package shipped with goscript (see prelude/prelude.go).
+const preludeAlias = "prelude"
+
+// The prelude source, embedded so the names it exports are known, and so it can be written into
+// the project (see writePrelude) rather than fetched with go get.
+//
+//go:embed prelude/prelude.go
+var preludeSource string
+
+// The import path of the project copy of the prelude, <module>/prelude, or "" if go.mod can't be read.
+func preludeImportPath() string {
+	modulePath := readModulePath()
+	if modulePath == "" {
+		return ""
+	}
+	return modulePath + "/" + preludeAlias
+}
+
+// Write the embedded prelude to <project>/prelude/prelude.go, unless the project copy is already the same.
+// It is rewritten whenever goscript's own copy changes, so the helpers a snippet is built against are
+// always the ones usesPrelude knows about. Works offline, unlike a go get of the published package.
+func writePrelude() {
+	preludeDir := projectDir + "/" + preludeAlias
+	filename := preludeDir + "/prelude.go"
+	if current, err := os.ReadFile(filename); err == nil && string(current) == preludeSource {
+		return
+	}
+	err := os.MkdirAll(preludeDir, 0755)
+	if err == nil {
+		err = writeFileAtomic(filename, []byte(preludeSource), 0644)
+	}
+	check(err, 1, "Unable to write "+filename)
+}
+
+// Return the exported top-level names of the prelude package (Die, Must, Sh, etc).
+func preludeNames() map[string]bool {
+	names := make(map[string]bool)
+	file, err := parser.ParseFile(token.NewFileSet(), "prelude.go", preludeSource, parser.SkipObjectResolution)
+	if check(err, 1, "Unable to parse the embedded prelude") {
+		return names
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil && decl.Name.IsExported() {
+				names[decl.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						if name.IsExported() {
+							names[name.Name] = true
+						}
+					}
+				case *ast.TypeSpec:
+					if spec.Name.IsExported() {
+						names[spec.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// Report whether the snippet (see findPackageRefs) calls any prelude helper by its bare name
+// (e.g. Die or Must), which it can only do if the prelude is dot-imported. A name the snippet
+// declares itself resolves to that declaration and doesn't count.
+func usesPrelude(decls string, body string) bool {
+	src := "package main\n" + decls + "\nfunc main() {\n" + body + "\n}\n"
+	file, _ := parser.ParseFile(token.NewFileSet(), "", src, parser.AllErrors)
+	if file == nil {
+		return false
+	}
+	names := preludeNames()
+	for _, ident := range file.Unresolved {
+		if names[ident.Name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// Package prelude provides the helpers most scripts end up writing by hand: exiting on errors,
+// reading arguments and environment variables with defaults, asking for confirmation,
+// running shell commands and logging to stderr.
+//
+// In goscript snippets the package is imported automatically when referenced as prelude.Name.
+// With the --prelude option it is dot-imported instead, so the helpers can be called as Die, Must, Sh, etc.
+package prelude
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The level of Log. Defaults to slog.LevelInfo, or the level named by GOSCRIPT_LOG_LEVEL
+// (debug, info, warn or error). Change it with LogLevel.Set(slog.LevelDebug).
+var LogLevel = new(slog.LevelVar)
+
+// Structured logging to stderr, e.g. Log.Info("copied", "files", n).
+var Log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: LogLevel}))
+
+func init() {
+	if level := os.Getenv("GOSCRIPT_LOG_LEVEL"); level != "" {
+		LogLevel.UnmarshalText([]byte(level))
+	}
+}
+
+// Die prints the message to stderr and exits with status 1. The arguments are handled as in fmt.Printf.
+func Die(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	fmt.Fprint(os.Stderr, msg)
+	os.Exit(1)
+}
+
+// Must exits with the error message if err is not nil.
+func Must(err error) {
+	if err != nil {
+		Die("%v", err)
+	}
+}
+
+// MustV returns v, or exits with the error message if err is not nil, e.g. data := MustV(os.ReadFile(name)).
+func MustV[T any](v T, err error) T {
+	Must(err)
+	return v
+}
+
+// Args returns the command line argument at position i (os.Args[i], so 1 is the first argument),
+// or def if there are fewer arguments.
+func Args(i int, def string) string {
+	if i < len(os.Args) {
+		return os.Args[i]
+	}
+	return def
+}
+
+// Env returns the value of the environment variable, or def if it is unset or empty.
+func Env(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// Confirm prints the prompt followed by [y/N] and reports whether the answer read from stdin is y or yes.
+func Confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Sh runs the command line with the shell (sh -c, or cmd /C on Windows) and returns its output
+// without the trailing newline. Stderr passes through. If the command fails, Sh exits with its error.
+// The arguments are handled as in fmt.Sprintf, e.g. Sh("wc -l < %s", name).
+func Sh(format string, args ...any) string {
+	cmdLine := format
+	if len(args) > 0 {
+		cmdLine = fmt.Sprintf(format, args...)
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", cmdLine)
+	} else {
+		cmd = exec.Command("sh", "-c", cmdLine)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Run(); err != nil {
+		Die("%s: %v", cmdLine, err)
+	}
+	return strings.TrimRight(out.String(), "\r\n")
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/fkmiec/goscript/util"
+)
+
+// A package reference found in a snippet, along with the exported names selected from it
+// (e.g. rand.Intn and rand.Seed give {Name: "rand", Symbols: ["Intn", "Seed"]}).
+type pkgRef struct {
+	Name    string
+	Symbols []string
+}
+
+// Parse the snippet (its top-level declarations and the body of the main function, see splitSnippet)
+// and return the identifiers used on the left side of a selector expression (e.g. pkg.Func) that do
+// not resolve to any declaration within the snippet. These are the candidate package references, in order of first appearance.
+// Selectors inside string literals and comments are never seen, and locals that shadow a
+// package name (e.g. strings := []string{}) resolve to the local and are skipped.
+func findPackageRefs(decls string, body string) []pkgRef {
+	src := "package main\n" + decls + "\nfunc main() {\n" + body + "\n}\n"
+	fset := token.NewFileSet()
+	//Syntax errors are reported by the compiler later. Use whatever portion of the AST was parsed.
+	file, _ := parser.ParseFile(fset, "", src, parser.AllErrors|parser.SkipObjectResolution)
+	if file == nil {
+		return nil
+	}
+	return collectPackageRefs(fset, file)
+}
+
+// Return the unresolved selector expressions (e.g. pkg.Func) in a parsed file, grouped by name.
+// An identifier is unresolved if it doesn't refer to anything declared in the file (see resolveIdents).
+// Note that identifiers naming imported packages are unresolved too.
+func collectPackageRefs(fset *token.FileSet, file *ast.File) []pkgRef {
+	info := resolveIdents(fset, file)
+	var refs []pkgRef
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		//Only a bare identifier can be a package name
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if obj := info.Uses[ident]; obj != nil {
+			if _, isPkg := obj.(*types.PkgName); !isPkg {
+				return true //A local, parameter, type, etc.
+			}
+		}
+		i := slices.IndexFunc(refs, func(ref pkgRef) bool { return ref.Name == ident.Name })
+		if i < 0 {
+			refs = append(refs, pkgRef{Name: ident.Name})
+			i = len(refs) - 1
+		}
+		if !slices.Contains(refs[i].Symbols, sel.Sel.Name) {
+			refs[i].Symbols = append(refs[i].Symbols, sel.Sel.Name)
+		}
+		return true
+	})
+	return refs
+}
+
+// Collect the names declared anywhere in the template (e.g. the handler's w and r in the http template,
+// or the helpers of the eval template). A selector on one of them in the snippet (e.g. w.Write) is not a
+// package reference, so it must not be resolved or looked up in the package index.
+func templateDeclaredNames(tmplName string, repl Repl) map[string]bool {
+	names := make(map[string]bool)
+	text, _, err := readTemplate(tmplName)
+	if err != nil {
+		return names
+	}
+	tmpl, err := template.New(tmplName).Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return names
+	}
+	repl.Imports, repl.Decls, repl.Code, repl.Begin, repl.End = nil, "", "", "", ""
+	var src bytes.Buffer
+	if tmpl.Execute(&src, repl) != nil {
+		return names
+	}
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", src.Bytes(), parser.AllErrors|parser.SkipObjectResolution)
+	if file == nil {
+		return names
+	}
+	for ident, obj := range resolveIdents(fset, file).Defs {
+		if obj != nil { //nil for the package name
+			names[ident.Name] = true
+		}
+	}
+	return names
+}
+
+// Resolve the identifiers of a parsed file to the objects they declare (Defs) or refer to (Uses) by type
+// checking it. Imports are empty packages (see emptyImporter), so nothing is loaded. References into them,
+// and anything else that doesn't resolve, are type errors, which are ignored: only the scopes matter here.
+func resolveIdents(fset *token.FileSet, file *ast.File) *types.Info {
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: emptyImporter{}, FakeImportC: true, Error: func(error) {}}
+	conf.Check("main", fset, []*ast.File{file}, info)
+	return info
+}
+
+// An importer that gives every import path an empty package, named as goimports would guess (see
+// guessPackageName), so a file can be type checked for the names it declares without loading anything.
+type emptyImporter struct{}
+
+func (emptyImporter) Import(path string) (*types.Package, error) {
+	pkg := types.NewPackage(path, guessPackageName(path))
+	pkg.MarkComplete()
+	return pkg, nil
+}
+
+// Build the alias table used to resolve package references. Later sources take precedence:
+// the built-in util.ImportsMap, then the generated stdimports.json (see --refresh-imports),
+// then the user's imports.json.
+func loadImportsMap() map[string]string {
+	importsMap := make(map[string]string)
+	for alias, entry := range loadAliasEntries() {
+		importsMap[alias] = entry.Path
+	}
+	return importsMap
+}
+
+// Gather the import paths competing for each package name that has more than one, starting
+// from util.CollidingImports and replacing with the generated stdimports.json entries. A name
+// mapped explicitly in imports.json is the user's choice and is never treated as ambiguous.
+func loadImportCandidates() map[string][]string {
+	candidates := maps.Clone(util.CollidingImports)
+	for name, paths := range readGeneratedImports() {
+		if len(paths) > 1 {
+			candidates[name] = paths
+		}
+	}
+	for key := range readUserImports() {
+		delete(candidates, key)
+	}
+	return candidates
+}
+
+// Pick the import path for a package reference. If several packages share the name, keep those
+// that export every symbol the snippet selects from it. If more than one remains, use the
+// preference from the [imports] section of goscript.conf, or else the default: the built-in
+// mapping in util.ImportsMap, or the first candidate (candidates are ordered by compareCandidates).
+// The reference is ambiguous only if the default doesn't export the symbols either.
+func resolveImport(ref pkgRef, importsMap map[string]string, candidates map[string][]string) (string, error) {
+	paths := candidates[ref.Name]
+	if len(paths) < 2 {
+		return importsMap[ref.Name], nil
+	}
+
+	var exporting []string
+	for _, path := range paths {
+		exports, err := exportsSymbols(path, ref.Symbols)
+		if err != nil {
+			//Without export data we can't tell the candidates apart, so fall back to the default mapping.
+			return importsMap[ref.Name], nil
+		}
+		if exports {
+			exporting = append(exporting, path)
+		}
+	}
+	if len(exporting) == 1 {
+		return exporting[0], nil
+	}
+
+	preferred := readProjectConfig()["imports"][ref.Name]
+	if len(exporting) == 0 {
+		return "", fmt.Errorf("No package named %s exports all of %s. Candidates: %s",
+			ref.Name, qualify(ref.Name, ref.Symbols), strings.Join(paths, ", "))
+	} else if slices.Contains(exporting, preferred) {
+		return preferred, nil
+	}
+	defaultPath := util.ImportsMap[ref.Name]
+	if defaultPath == "" {
+		defaultPath = paths[0]
+	}
+	if slices.Contains(exporting, defaultPath) {
+		return defaultPath, nil
+	}
+	return "", fmt.Errorf("Ambiguous package %s: %s is exported by each of %s.\nAdd a preference to %s, e.g.\n[imports]\n%s = %s",
+		ref.Name, qualify(ref.Name, ref.Symbols), strings.Join(exporting, ", "), projectDir+"/goscript.conf", ref.Name, exporting[0])
+}
+
+// Shared importer so each candidate package's export data is only loaded once per run.
+var symbolImporter = importer.Default()
+
+// Report whether the package at importPath exports every one of the given symbols. Export data
+// comes from the local toolchain (the gc importer runs 'go list -export' as needed).
+func exportsSymbols(importPath string, symbols []string) (bool, error) {
+	pkg, err := symbolImporter.Import(importPath)
+	if err != nil {
+		return false, err
+	}
+	for _, symbol := range symbols {
+		obj := pkg.Scope().Lookup(symbol)
+		if obj == nil || !obj.Exported() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Format symbols as pkg.Symbol for error messages (e.g. "rand.Intn, rand.Prime").
+func qualify(name string, symbols []string) string {
+	qualified := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		qualified[i] = name + "." + symbol
+	}
+	return strings.Join(qualified, ", ")
+}
+
+// Enumerate the standard library of the local Go toolchain with 'go list std' and write the
+// generated alias table to the project. Internal and vendored packages are dropped because they
+// cannot be imported. Packages sharing a name (e.g. crypto/rand and math/rand) are all kept,
+// ordered by preference, so the first entry is the default.
+func refreshImports() {
+	cmd := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}} {{.Dir}}", "std")
+	cmd.Dir = projectDir
+	out, err := cmd.CombinedOutput()
+	check(err, 2, fmt.Sprintf("Unable to list the standard library.\n%s", out))
+
+	generated := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || !isImportable(fields[0], fields[2]) {
+			continue
+		}
+		importPath, name := fields[0], fields[1]
+		generated[name] = append(generated[name], importPath)
+	}
+	for name, paths := range generated {
+		slices.SortFunc(paths, func(a, b string) int {
+			return compareCandidates(name, a, b)
+		})
+	}
+	writeGeneratedImports(generated)
+	fmt.Printf("Wrote %d package aliases to %s\n", len(generated), projectDir+"/stdimports.json")
+}
+
+// Internal packages may only be imported from within their parent tree, and vendored packages
+// are private copies used by the standard library itself. Packages in dir (if given) that only
+// build with a GOEXPERIMENT (e.g. encoding/json/v2) are dropped too, since they come and go with it.
+func isImportable(importPath string, dir string) bool {
+	if strings.HasPrefix(importPath, "vendor/") {
+		return false
+	}
+	if slices.Contains(strings.Split(importPath, "/"), "internal") {
+		return false
+	}
+	return dir == "" || !isExperimentGated(dir)
+}
+
+// Report whether none of the Go files in dir would build with every GOEXPERIMENT turned off.
+func isExperimentGated(dir string) bool {
+	ctxt := build.Default
+	ctxt.ToolTags = slices.DeleteFunc(slices.Clone(ctxt.ToolTags), func(tag string) bool {
+		return strings.HasPrefix(tag, "goexperiment.")
+	})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := ctxt.MatchFile(dir, name); err != nil || match {
+			return false
+		}
+	}
+	return true
+}
+
+// Order candidate import paths for a package name. The built-in mapping wins (it reflects the
+// long-standing default), then the shorter path, then alphabetical order.
+func compareCandidates(name, a, b string) int {
+	preferred := util.ImportsMap[name]
+	if a == preferred || b == preferred {
+		if a == b {
+			return 0
+		} else if a == preferred {
+			return -1
+		}
+		return 1
+	}
+	if depthA, depthB := strings.Count(a, "/"), strings.Count(b, "/"); depthA != depthB {
+		return depthA - depthB
+	}
+	return strings.Compare(a, b)
+}
+
+func readGeneratedImports() map[string][]string {
+	var generated map[string][]string
+	filename := projectDir + "/stdimports.json"
+	if checkFileExists(filename) {
+		file, err := os.Open(filename)
+		check(err, 2, "")
+		defer file.Close()
+
+		byteValue, _ := io.ReadAll(file)
+		json.Unmarshal(byteValue, &generated)
+	}
+	return generated
+}
+
+func writeGeneratedImports(generated map[string][]string) {
+	filename := projectDir + "/stdimports.json"
+	jsonData, err := json.MarshalIndent(generated, "", "    ")
+	check(err, 2, "Unable to marshal content for stdimports.json file.")
+	err = writeFileAtomic(filename, jsonData, 0644)
+	check(err, 2, "")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindPackageRefs(t *testing.T) {
+	tests := []struct {
+		name  string
+		decls string
+		body  string
+		want  []pkgRef
+	}{
+		{
+			name: "package functions",
+			body: `fmt.Println(strings.ToUpper("a"), strings.Repeat("b", 2))`,
+			want: []pkgRef{{"fmt", []string{"Println"}}, {"strings", []string{"ToUpper", "Repeat"}}},
+		},
+		{
+			name: "strings and comments",
+			body: `fmt.Println("config.json") //os.Exit`,
+			want: []pkgRef{{"fmt", []string{"Println"}}},
+		},
+		{
+			name: "local shadows a package",
+			body: "strings := []string{\"a\"}\nfmt.Println(len(strings))\nresp := struct{ Body string }{}\n_ = resp.Body",
+			want: []pkgRef{{"fmt", []string{"Println"}}},
+		},
+		{
+			name: "used before a shadowing declaration",
+			body: "x := strings.ToUpper(\"a\")\nstrings := x\n_ = strings",
+			want: []pkgRef{{"strings", []string{"ToUpper"}}},
+		},
+		{
+			name: "shadowed in an inner scope only",
+			body: "if path := \"a\"; path != \"\" { _ = path }\nfmt.Println(path.Base(\"/a\"))",
+			want: []pkgRef{{"fmt", []string{"Println"}}, {"path", []string{"Base"}}},
+		},
+		{
+			name:  "declarations",
+			decls: "type pt struct{ x int }\n\nfunc (p pt) show(w io.Writer) { fmt.Fprintln(w, p.x) }",
+			body:  "pt{1}.show(os.Stdout)",
+			want:  []pkgRef{{"io", []string{"Writer"}}, {"fmt", []string{"Fprintln"}}, {"os", []string{"Stdout"}}},
+		},
+		{
+			name: "function literal and range",
+			body: "f := func(r *http.Request) string { return r.URL.Path }\nfor i, arg := range os.Args { _, _ = i, arg.x }\n_ = f",
+			want: []pkgRef{{"http", []string{"Request"}}, {"os", []string{"Args"}}},
+		},
+		{
+			name: "syntax error",
+			body: "fmt.Println(",
+			want: []pkgRef{{"fmt", []string{"Println"}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findPackageRefs(test.decls, test.body); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("findPackageRefs() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
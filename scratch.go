@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scratch directories left by runs that were killed are removed once they are this old.
+const scratchMaxAge = 24 * time.Hour
+
+// Code run without --name (ad-hoc snippets, shebang scripts) is built in a scratch directory of its own,
+// <project>/.scratch/gocmd-<pid>-<random>, so src and bin only hold named commands. The directory is in
+// the project module, so the build resolves dependencies with the project go.mod like a named command,
+// but the go tool ignores directories starting with a dot, so go mod tidy, go vet ./... and gopls don't see it.
+func createScratchDir() string {
+	pruneScratchDirs()
+	dir := projectDir + "/.scratch/gocmd-" + uniqueSuffix()
+	err := os.MkdirAll(dir, 0755)
+	check(err, 2, "Unable to create a scratch directory for the build")
+	return dir
+}
+
+// Remove the scratch directories of runs that were killed (e.g. by SIGKILL) before cleaning up.
+func pruneScratchDirs() {
+	list, _ := os.ReadDir(projectDir + "/.scratch")
+	for _, entry := range list {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > scratchMaxAge {
+			os.RemoveAll(filepath.Join(projectDir, ".scratch", entry.Name()))
+		}
+	}
+}
+
+// Prefix the source read from filename (see readSourceFile) with a //line directive, so the compiler
+// reports positions in the scratch main.go as lines and columns of the user's file.
+func addLineDirective(src *bytes.Buffer, filename string) *bytes.Buffer {
+	return bytes.NewBufferString(lineDirective(filename, 1) + src.String())
+}
+
+// A //line directive that makes the next line the given line of filename, as read by readSourceFile.
+// The shebang line readSourceFile strips is counted, so line numbers match what the user sees in an editor.
+// The path is absolute, since a relative one would be taken as relative to the scratch directory.
+func lineDirective(filename string, line int) string {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		absFilename = filename
+	}
+	return fmt.Sprintf("//line %s:%d:1\n", absFilename, line+countShebangLines(filename))
+}
+
+// Rewrite compiler output for a source with line directives for filename (see addLineDirective), so
+// diagnostics show the path the user gave rather than the one go build prints (relative to the project).
+func showUserFilename(out, filename string) string {
+	shown, err := filepath.Abs(filename)
+	if err != nil {
+		return out
+	}
+	if rel, err := filepath.Rel(projectDir, shown); err == nil && len(rel) < len(shown) {
+		shown = rel //As go build shortens paths, relative to its working directory
+	}
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if rest, found := strings.CutPrefix(line, shown+":"); found {
+			lines[i] = filename + ":" + rest
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+type scannedToken struct {
+	offset int
+	tok    token.Token
+}
+
+// Split a --code snippet into top-level declarations and the statements for the body of main.
+// Function and method declarations and type declarations are moved to file scope. If moveVars,
+// so are var and const declarations that come before the first statement (a preamble, as in a
+// script). Only the script template runs the code once, so other templates keep them in the body
+// (e.g. --lines runs the code for each line, and the http template's code can use w and r).
+// In the body, each moved declaration is blanked out rather than removed so the remaining
+// statements keep their original line and column.
+func splitSnippet(code string, moveVars bool) (decls string, body string) {
+	parts, body := splitSnippetParts(code, moveVars)
+	var declTexts []string
+	for _, part := range parts {
+		declTexts = append(declTexts, part.text)
+	}
+	return strings.Join(declTexts, "\n\n"), body
+}
+
+// A declaration moved out of a snippet, and its offset in the snippet.
+type snippetPart struct {
+	offset int
+	text   string
+}
+
+// Split a snippet as splitSnippet does, keeping the offset of each declaration (see annotateSnippet).
+func splitSnippetParts(code string, moveVars bool) ([]snippetPart, string) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s scanner.Scanner
+	s.Init(file, []byte(code), nil, 0) //Syntax errors are left for the compiler to report
+
+	var tokens []scannedToken
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		tokens = append(tokens, scannedToken{file.Offset(pos), tok})
+	}
+
+	var parts []snippetPart
+	bodyBytes := []byte(code)
+	depth := 0
+	seenStatement := false
+	for i := 0; i < len(tokens); i++ {
+		atStatementStart := i == 0 || (tokens[i-1].tok == token.SEMICOLON && depth == 0)
+		if atStatementStart {
+			isDecl := false
+			switch tokens[i].tok {
+			case token.FUNC:
+				isDecl = isFuncDecl(tokens[i+1:])
+			case token.TYPE:
+				isDecl = true
+			case token.VAR, token.CONST:
+				isDecl = moveVars && !seenStatement
+			}
+			if isDecl {
+				end := endOfStatement(tokens, i, len(code))
+				parts = append(parts, snippetPart{tokens[i].offset, code[tokens[i].offset:end]})
+				for j := tokens[i].offset; j < end; j++ {
+					if bodyBytes[j] != '\n' {
+						bodyBytes[j] = ' '
+					}
+				}
+				//Skip past the declaration, to the token that ends it
+				for i < len(tokens)-1 && tokens[i+1].offset < end {
+					i++
+				}
+				continue
+			}
+			if tokens[i].tok != token.SEMICOLON {
+				seenStatement = true
+			}
+		}
+		switch tokens[i].tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		}
+	}
+	return parts, string(bodyBytes)
+}
+
+// Report whether the tokens following 'func' at the start of a statement make it a declaration:
+// func Name... or func (recv) Name... A function literal (func(...) {...}) is a statement.
+func isFuncDecl(rest []scannedToken) bool {
+	if len(rest) == 0 {
+		return false
+	}
+	if rest[0].tok == token.IDENT {
+		return true
+	}
+	if rest[0].tok != token.LPAREN {
+		return false
+	}
+	//Skip the parenthesized list, then a method name must follow
+	depth := 0
+	for i, t := range rest {
+		switch t.tok {
+		case token.LPAREN:
+			depth++
+		case token.RPAREN:
+			depth--
+		}
+		if depth == 0 {
+			return i+2 < len(rest) && rest[i+1].tok == token.IDENT && (rest[i+2].tok == token.LPAREN || rest[i+2].tok == token.LBRACK)
+		}
+	}
+	return false
+}
+
+// Return the offset just past the statement starting at tokens[start]: the position of the
+// semicolon (explicit or inserted at a newline) that ends it at the same nesting depth.
+func endOfStatement(tokens []scannedToken, start int, codeLen int) int {
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		switch tokens[i].tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				return tokens[i].offset
+			}
+		}
+	}
+	return codeLen
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		moveVars bool
+		decls    string
+		body     string
+	}{
+		{
+			name:  "statements only",
+			code:  `fmt.Println("hi")`,
+			decls: "",
+			body:  `fmt.Println("hi")`,
+		},
+		{
+			name:  "func",
+			code:  "func sq(x int) int { return x * x }; fmt.Println(sq(3))",
+			decls: "func sq(x int) int { return x * x }",
+			body:  "                                   ; fmt.Println(sq(3))",
+		},
+		{
+			name:  "method and type",
+			code:  "type pt struct{ x int }\nfunc (p pt) X() int { return p.x }\nfmt.Println(pt{1}.X())",
+			decls: "type pt struct{ x int }\n\nfunc (p pt) X() int { return p.x }",
+			body:  "                       \n                                  \nfmt.Println(pt{1}.X())",
+		},
+		{
+			name:  "generic method",
+			code:  "func (b box[T]) Get() T { return b.v }",
+			decls: "func (b box[T]) Get() T { return b.v }",
+			body:  "                                      ",
+		},
+		{
+			name:  "func literal",
+			code:  "f := func(x int) int { return x }; func() { f(1) }()",
+			decls: "",
+			body:  "f := func(x int) int { return x }; func() { f(1) }()",
+		},
+		{
+			name:  "var preamble without moveVars",
+			code:  "var n = len(os.Args)\nfmt.Println(n)",
+			decls: "",
+			body:  "var n = len(os.Args)\nfmt.Println(n)",
+		},
+		{
+			name:     "var preamble with moveVars",
+			code:     "var n = len(os.Args)\nconst k = 2\nfmt.Println(n * k)",
+			moveVars: true,
+			decls:    "var n = len(os.Args)\n\nconst k = 2",
+			body:     "                    \n           \nfmt.Println(n * k)",
+		},
+		{
+			name:     "var after a statement",
+			code:     "fmt.Println()\nvar n = 1\nfmt.Println(n)",
+			moveVars: true,
+			decls:    "",
+			body:     "fmt.Println()\nvar n = 1\nfmt.Println(n)",
+		},
+		{
+			name:  "type in a block",
+			code:  "if true { type t int; fmt.Println(t(1)) }",
+			decls: "",
+			body:  "if true { type t int; fmt.Println(t(1)) }",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decls, body := splitSnippet(test.code, test.moveVars)
+			if decls != test.decls {
+				t.Errorf("decls = %q, want %q", decls, test.decls)
+			}
+			if body != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+			if strings.Count(body, "\n") != strings.Count(test.code, "\n") {
+				t.Errorf("body has %d lines, the code %d", strings.Count(body, "\n"), strings.Count(test.code, "\n"))
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		args []string
+		err  bool
+	}{
+		{s: "", args: nil},
+		{s: "  -s   -w\t", args: []string{"-s", "-w"}},
+		{s: `-X "main.version=1.0 beta"`, args: []string{"-X", "main.version=1.0 beta"}},
+		{s: `-X 'main.msg=say "hi"'`, args: []string{"-X", `main.msg=say "hi"`}},
+		{s: `-X main.empty=""`, args: []string{"-X", "main.empty="}},
+		{s: `""`, args: []string{""}},
+		{s: `-X "main.v=1`, err: true},
+	}
+	for _, test := range tests {
+		args, err := splitArgs(test.s)
+		if (err != nil) != test.err {
+			t.Errorf("splitArgs(%q) error = %v, want error %v", test.s, err, test.err)
+			continue
+		}
+		if !slices.Equal(args, test.args) {
+			t.Errorf("splitArgs(%q) = %q, want %q", test.s, args, test.args)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The project-wide inputs a command's binary depends on, read once for all commands.
+type buildInputs struct {
+	goVersion string    //Version of the go toolchain that would build the commands now
+	goMod     time.Time //Modification times of go.mod and go.sum
+	goSum     time.Time
+	config    time.Time //Modification time of goscript.conf, which holds the [build] settings and profiles
+	libPath   string    //Import path of the project helper library, if any
+	lib       time.Time //Latest modification time of the lib sources
+}
+
+func readBuildInputs() buildInputs {
+	var inputs buildInputs
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Dir = projectDir
+	if out, err := cmd.Output(); err == nil {
+		inputs.goVersion = strings.TrimSpace(string(out))
+	}
+	inputs.goMod = modTime(projectDir + "/go.mod")
+	inputs.goSum = modTime(projectDir + "/go.sum")
+	inputs.config = modTime(projectDir + "/goscript.conf")
+	inputs.libPath = libImportPath()
+	if inputs.libPath != "" {
+		for _, file := range libFiles() {
+			if t := modTime(file); t.After(inputs.lib) {
+				inputs.lib = t
+			}
+		}
+	}
+	return inputs
+}
+
+// The modification time of a file, or the zero time if it doesn't exist.
+func modTime(filename string) time.Time {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return fileInfo.ModTime()
+}
+
+// Return the reasons the binary of the named command is out of date, or nil if it is up to date.
+// A binary is stale if it is older than its source, go.mod, go.sum, goscript.conf, the template the source was
+// generated from or (if the command uses it) the project helper library, or if it was built by a
+// different version of Go than the one installed. With --profile, a command saved with another profile is stale too.
+func staleReasons(name string, inputs buildInputs) []string {
+	srcFilename := projectDir + "/src/" + name + ".go"
+	binFilename := projectDir + "/bin/" + name
+	built := modTime(binFilename)
+	if built.IsZero() {
+		return []string{"no binary"}
+	}
+
+	var reasons []string
+	if modTime(srcFilename).After(built) {
+		reasons = append(reasons, "source changed")
+	}
+	if inputs.goMod.After(built) {
+		reasons = append(reasons, "go.mod changed")
+	}
+	if inputs.goSum.After(built) {
+		reasons = append(reasons, "go.sum changed")
+	}
+	if inputs.config.After(built) {
+		reasons = append(reasons, "goscript.conf changed")
+	}
+	src, _ := os.ReadFile(srcFilename)
+	if tmplName := readTemplateHeader(string(src)); tmplName != "" {
+		//Only a project override can change without goscript itself changing
+		if modTime(projectDir + "/" + templateFile(tmplName)).After(built) {
+			reasons = append(reasons, "template "+tmplName+" changed")
+		}
+	}
+	if buildProfile != "" && readProfileHeader(string(src)) != buildProfile {
+		reasons = append(reasons, "profile "+buildProfile) //--profile with --recompile or --stale
+	}
+	if inputs.lib.After(built) && usesLib(srcFilename, inputs.libPath) {
+		reasons = append(reasons, "lib changed")
+	}
+	if info, err := buildinfo.ReadFile(binFilename); err != nil {
+		reasons = append(reasons, "unreadable build info")
+	} else if inputs.goVersion != "" && info.GoVersion != inputs.goVersion {
+		reasons = append(reasons, fmt.Sprintf("built with %s, now %s", info.GoVersion, inputs.goVersion))
+	}
+	return reasons
+}
+
+// Handle --stale. List the commands --recompile would rebuild, and why.
+func listStaleCommands() {
+	inputs := readBuildInputs()
+	var count int
+	for _, name := range getSourceList() {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		name = name[:len(name)-3]
+		if reasons := staleReasons(name, inputs); len(reasons) > 0 {
+			fmt.Printf("%s: %s\n", name, strings.Join(reasons, ", "))
+			count++
+		}
+	}
+	if count == 0 {
+		fmt.Println("All commands are up to date.")
+	}
+}
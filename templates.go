@@ -0,0 +1,285 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// The template used when --tmpl isn't given. It lives at <project>/script.tmpl.
+// Other templates live at <project>/templates/<name>.tmpl.
+const defaultTemplate = "script"
+
+// Matches the default imports a template declares in a template comment, e.g.
+// {{/* goscript:imports context time */}}. Entries are aliases from the imports table or import paths.
+var templateImportsMatcher = regexp.MustCompile(`goscript:imports\s+([^*]*)\*/`)
+
+// Functions available to templates, in addition to the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"indent": indent,
+	"quote":  strconv.Quote,
+	"env":    env,
+}
+
+// Indent each non-blank line of s with n spaces, e.g. {{.Vars.body | indent 4}}.
+func indent(n int, s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = strings.Repeat(" ", n) + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// The value of an environment variable when the source is generated, or def if it is unset or empty,
+// e.g. {{env "USER"}} or {{env "PORT" "8080"}}.
+func env(key string, def ...string) string {
+	if value := os.Getenv(key); value != "" || len(def) == 0 {
+		return value
+	}
+	return def[0]
+}
+
+// Template variables set with -D name=value. The flag can be repeated.
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	var pairs []string
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v templateVars) Set(s string) error {
+	name, value, found := strings.Cut(s, "=")
+	if !found || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+	v[strings.TrimSpace(name)] = value
+	return nil
+}
+
+// The variables exposed to templates as .Vars. Those in the [vars] section of goscript.conf are the
+// defaults and -D flags override them.
+func loadTemplateVars(flags templateVars) map[string]string {
+	vars := make(map[string]string)
+	for name, value := range readProjectConfig()["vars"] {
+		vars[name] = value
+	}
+	for name, value := range flags {
+		vars[name] = value
+	}
+	return vars
+}
+
+// The canonical templates, compiled into goscript. A file at the same path in the project
+// (e.g. <project>/script.tmpl or <project>/templates/http.tmpl) overrides the embedded one.
+//
+//go:embed script.tmpl templates/*.tmpl
+var embeddedTemplates embed.FS
+
+// The path of a template relative to the project directory (and within embeddedTemplates).
+func templateFile(name string) string {
+	if name == "" || name == defaultTemplate {
+		return "script.tmpl"
+	}
+	return "templates/" + name + ".tmpl"
+}
+
+// Read a template, preferring the project override to the embedded template.
+// Returns the text and where it came from (the project file path, or "embedded").
+func readTemplate(name string) ([]byte, string, error) {
+	projectFile := projectDir + "/" + templateFile(name)
+	if checkFileExists(projectFile) {
+		text, err := os.ReadFile(projectFile)
+		return text, projectFile, err
+	}
+	text, err := embeddedTemplates.ReadFile(templateFile(name))
+	if err != nil {
+		return nil, "", fmt.Errorf("Template %s not found. Available templates: %s", name, strings.Join(getTemplateList(), ", "))
+	}
+	return text, "embedded", nil
+}
+
+// Return the default imports declared by a template, formatted as import specs.
+func templateImports(text string) []string {
+	var imports []string
+	importsMap := loadImportsMap()
+	for _, m := range templateImportsMatcher.FindAllStringSubmatch(text, -1) {
+		for _, entry := range strings.Fields(m[1]) {
+			if path, found := importsMap[entry]; found {
+				imports = append(imports, formatImport(entry, path))
+			} else {
+				imports = append(imports, fmt.Sprintf("\"%s\"", entry))
+			}
+		}
+	}
+	return imports
+}
+
+// Return the names of the available templates (embedded and project), starting with the default.
+func getTemplateList() []string {
+	var names []string
+	embedded, _ := embeddedTemplates.ReadDir("templates")
+	project, _ := os.ReadDir(projectDir + "/templates")
+	for _, entry := range append(embedded, project...) {
+		name, isTemplate := strings.CutSuffix(entry.Name(), ".tmpl")
+		if !entry.IsDir() && isTemplate && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return append([]string{defaultTemplate}, names...)
+}
+
+// Handle --template-diff. Show how the project override of a template differs from the embedded one.
+func diffTemplate(name string) {
+	if name == "" {
+		name = defaultTemplate
+	}
+	embedded, err := embeddedTemplates.ReadFile(templateFile(name))
+	projectFile := projectDir + "/" + templateFile(name)
+	override, overrideErr := os.ReadFile(projectFile)
+	if err != nil {
+		check(fmt.Errorf("Template %s is not one of the embedded templates. Nothing to compare it with.", name), 2, "")
+	} else if overrideErr != nil {
+		fmt.Printf("No project override for template %s (%s). The embedded template is used.\n", name, projectFile)
+		return
+	}
+	diff := diffLines(string(embedded), string(override), "embedded/"+templateFile(name), projectFile)
+	if diff == "" {
+		fmt.Printf("%s is identical to the embedded template.\n", projectFile)
+		return
+	}
+	fmt.Print(diff)
+}
+
+// Handle --template-reset. Restore the project copy of a template to the embedded version.
+func resetTemplate(name string) {
+	if name == "" {
+		name = defaultTemplate
+	}
+	embedded, err := embeddedTemplates.ReadFile(templateFile(name))
+	if err != nil {
+		check(fmt.Errorf("Template %s is not one of the embedded templates. Nothing to reset it to.", name), 2, "")
+	}
+	projectFile := projectDir + "/" + templateFile(name)
+	os.MkdirAll(filepath.Dir(projectFile), 0766)
+	err = os.WriteFile(projectFile, embedded, 0644)
+	check(err, 2, "")
+	fmt.Printf("%s restored to the embedded template.\n", projectFile)
+}
+
+// A unified diff of two texts, with 3 lines of context around each change. Returns "" if they are equal.
+func diffLines(a, b, nameA, nameB string) string {
+	linesA, linesB := splitLines(a), splitLines(b)
+
+	//Longest common subsequence table, computed from the end so the edit script can be read forwards
+	lcs := make([][]int, len(linesA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte //' ', '-' or '+'
+		line string
+		i, j int //Line numbers (0-based) in a and b
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(linesA) || j < len(linesB) {
+		switch {
+		case i < len(linesA) && j < len(linesB) && linesA[i] == linesB[j]:
+			edits = append(edits, edit{' ', linesA[i], i, j})
+			i++
+			j++
+		case i < len(linesA) && (j == len(linesB) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', linesA[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', linesB[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	for start := 0; start < len(edits); {
+		//Find the next change, and extend the hunk while changes are within 2*context lines of each other
+		first := slices.IndexFunc(edits[start:], func(e edit) bool { return e.op != ' ' })
+		if first < 0 {
+			break
+		}
+		first += start
+		last := first
+		for k := first; k < len(edits) && k <= last+2*context; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		from, to := max(first-context, start), min(last+context+1, len(edits))
+
+		var countA, countB int
+		var hunk strings.Builder
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+			hunk.WriteByte(e.op)
+			hunk.WriteString(strings.TrimSuffix(e.line, "\n") + "\n")
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n%s", edits[from].i+1, countA, edits[from].j+1, countB, hunk.String())
+		start = to
+	}
+	return sb.String()
+}
+
+// Split text into lines, each keeping its newline. A final newline ends the last line rather than
+// starting an empty one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Record the template that produced a source file, so named commands remember it (see --list).
+func templateHeader(name string) string {
+	if name == "" {
+		name = defaultTemplate
+	}
+	return "//goscript:template " + name + "\n\n"
+}
+
+// Return the template recorded in a source file's header, or "" if it wasn't generated from one.
+func readTemplateHeader(src string) string {
+	if names := readHeaderDirectives(src)["template"]; len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
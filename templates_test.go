@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestDiffLines(t *testing.T) {
+	const header = "--- a\n+++ b\n"
+	tests := []struct {
+		name string
+		a, b string
+		diff string
+	}{
+		{
+			name: "identical",
+			a:    "one\ntwo\nthree\n",
+			b:    "one\ntwo\nthree\n",
+			diff: "",
+		},
+		{
+			name: "empty",
+			a:    "",
+			b:    "",
+			diff: "",
+		},
+		{
+			name: "appended",
+			a:    "1\n2\n3\n4\n5\n",
+			b:    "1\n2\n3\n4\n5\n6\n",
+			diff: header + "@@ -3,3 +3,4 @@\n 3\n 4\n 5\n+6\n",
+		},
+		{
+			name: "changed last line",
+			a:    "1\n2\n3\n4\n5\n",
+			b:    "1\n2\n3\n4\nfive\n",
+			diff: header + "@@ -2,4 +2,4 @@\n 2\n 3\n 4\n-5\n+five\n",
+		},
+		{
+			name: "changed first line",
+			a:    "1\n2\n3\n4\n5\n",
+			b:    "one\n2\n3\n4\n5\n",
+			diff: header + "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			diff: header + "@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := diffLines(test.a, test.b, "a", "b"); diff != test.diff {
+				t.Errorf("diffLines() =\n%s\nwant\n%s", diff, test.diff)
+			}
+		})
+	}
+}
@@ -1,204 +1,111 @@
 package util
 
 var ImportsMap = map[string]string{
-	"script":        "github.com/bitfield/script",
-	"tar":           "archive/tar",
-	"zip":           "archive/zip",
-	"bufio":         "bufio",
-	"bytes":         "bytes",
-	"cmp":           "cmp",
-	"bzip2":         "compress/bzip2",
-	"flate":         "compress/flate",
-	"gzip":          "compress/gzip",
-	"lzw":           "compress/lzw",
-	"zlib":          "compress/zlib",
-	"heap":          "container/heap",
-	"list":          "container/list",
-	"ring":          "container/ring",
-	"context":       "context",
-	"crypto":        "crypto",
-	"aes":           "crypto/aes",
-	"cipher":        "crypto/cipher",
-	"des":           "crypto/des",
-	"dsa":           "crypto/dsa",
-	"ecdh":          "crypto/ecdh",
-	"ecdsa":         "crypto/ecdsa",
-	"ed25519":       "crypto/ed25519",
-	"elliptic":      "crypto/elliptic",
-	"hmac":          "crypto/hmac",
-	"alias":         "crypto/internal/alias",
-	"bigmod":        "crypto/internal/bigmod",
-	"boring":        "crypto/internal/boring",
-	"bbig":          "crypto/internal/boring/bbig",
-	"bcache":        "crypto/internal/boring/bcache",
-	"sig":           "crypto/internal/boring/sig",
-	"edwards25519":  "crypto/internal/edwards25519",
-	"field":         "crypto/internal/edwards25519/field",
-	"nistec":        "crypto/internal/nistec",
-	"fiat":          "crypto/internal/nistec/fiat",
-	"randutil":      "crypto/internal/randutil",
-	"md5":           "crypto/md5",
-	"rand":          "crypto/rand",
-	"rc4":           "crypto/rc4",
-	"rsa":           "crypto/rsa",
-	"sha1":          "crypto/sha1",
-	"sha256":        "crypto/sha256",
-	"sha512":        "crypto/sha512",
-	"subtle":        "crypto/subtle",
-	"tls":           "crypto/tls",
-	"x509":          "crypto/x509",
-	"pkix":          "crypto/x509/pkix",
-	"sql":           "database/sql",
-	"driver":        "database/sql/driver",
-	"buildinfo":     "debug/buildinfo",
-	"dwarf":         "debug/dwarf",
-	"elf":           "debug/elf",
-	"gosym":         "debug/gosym",
-	"macho":         "debug/macho",
-	"pe":            "debug/pe",
-	"plan9obj":      "debug/plan9obj",
-	"embed":         "embed",
-	"embedtest":     "embed/internal/embedtest",
-	"encoding":      "encoding",
-	"ascii85":       "encoding/ascii85",
-	"asn1":          "encoding/asn1",
-	"base32":        "encoding/base32",
-	"base64":        "encoding/base64",
-	"binary":        "encoding/binary",
-	"csv":           "encoding/csv",
-	"gob":           "encoding/gob",
-	"hex":           "encoding/hex",
-	"json":          "encoding/json",
-	"pem":           "encoding/pem",
-	"xml":           "encoding/xml",
-	"errors":        "errors",
-	"expvar":        "expvar",
-	"flag":          "flag",
-	"fmt":           "fmt",
-	"ast":           "go/ast",
-	"build":         "go/build",
-	"constraint":    "go/build/constraint",
-	"constant":      "go/constant",
-	"doc":           "go/doc",
-	"comment":       "go/doc/comment",
-	"format":        "go/format",
-	"importer":      "go/importer",
-	"gccgoimporter": "go/internal/gccgoimporter",
-	"gcimporter":    "go/internal/gcimporter",
-	"srcimporter":   "go/internal/srcimporter",
-	"typeparams":    "go/internal/typeparams",
-	"parser":        "go/parser",
-	"printer":       "go/printer",
-	"scanner":       "go/scanner",
-	"token":         "go/token",
-	"types":         "go/types",
-	"version":       "go/version",
-	"hash":          "hash",
-	"adler32":       "hash/adler32",
-	"crc32":         "hash/crc32",
-	"crc64":         "hash/crc64",
-	"fnv":           "hash/fnv",
-	"maphash":       "hash/maphash",
-	"html":          "html",
-	"template":      "html/template",
-	"image":         "image",
-	"color":         "image/color",
-	"palette":       "image/color/palette",
-	"draw":          "image/draw",
-	"gif":           "image/gif",
-	"imageutil":     "image/internal/imageutil",
-	"jpeg":          "image/jpeg",
-	"png":           "image/png",
-	"suffixarray":   "index/suffixarray",
-	"abi":           "internal/abi",
-	"bisect":        "internal/bisect",
-	"buildcfg":      "internal/buildcfg",
-	"bytealg":       "internal/bytealg",
-	"cfg":           "internal/cfg",
-	"chacha8rand":   "internal/chacha8rand",
-	"coverage":      "internal/coverage",
-	"calloc":        "internal/coverage/calloc",
-	"cformat":       "internal/coverage/cformat",
-	"cmerge":        "internal/coverage/cmerge",
-	"decodecounter": "internal/coverage/decodecounter",
-	"decodemeta":    "internal/coverage/decodemeta",
-	"encodecounter": "internal/coverage/encodecounter",
-	"encodemeta":    "internal/coverage/encodemeta",
-	"pods":          "internal/coverage/pods",
-	"rtcov":         "internal/coverage/rtcov",
-	"slicereader":   "internal/coverage/slicereader",
-	"slicewriter":   "internal/coverage/slicewriter",
-	"stringtab":     "internal/coverage/stringtab",
-	"test":          "internal/coverage/test",
-	"uleb128":       "internal/coverage/uleb128",
-	"cpu":           "internal/cpu",
-	"dag":           "internal/dag",
-	"diff":          "internal/diff",
-	"fmtsort":       "internal/fmtsort",
-	"fuzz":          "internal/fuzz",
-	"goarch":        "internal/goarch",
-	"godebug":       "internal/godebug",
-	"godebugs":      "internal/godebugs",
-	"goexperiment":  "internal/goexperiment",
-	"goos":          "internal/goos",
-	"goroot":        "internal/goroot",
-	"gover":         "internal/gover",
-	//"goversion":        "internal/goversion",
-	"intern":          "internal/intern",
-	"itoa":            "internal/itoa",
-	"lazyregexp":      "internal/lazyregexp",
-	"lazytemplate":    "internal/lazytemplate",
-	"nettrace":        "internal/nettrace",
-	"obscuretestdata": "internal/obscuretestdata",
-	"oserror":         "internal/oserror",
-	"pkgbits":         "internal/pkgbits",
-	"platform":        "internal/platform",
-	"poll":            "internal/poll",
-	"profile":         "internal/profile",
-	"race":            "internal/race",
-	"reflectlite":     "internal/reflectlite",
-	"safefilepath":    "internal/safefilepath",
-	"saferio":         "internal/saferio",
-	"singleflight":    "internal/singleflight",
-	"execenv":         "internal/syscall/execenv",
-	"unix":            "internal/syscall/unix",
-	"sysinfo":         "internal/sysinfo",
-	"testenv":         "internal/testenv",
-	"testlog":         "internal/testlog",
-	"testpty":         "internal/testpty",
-	"trace":           "internal/trace",
-	"traceviewer":     "internal/trace/traceviewer",
-	//"format":           "internal/trace/traceviewer/format",
-	"v2":    "internal/trace/v2",
-	"event": "internal/trace/v2/event",
-	"go122": "internal/trace/v2/event/go122",
-	//"go122":            "internal/trace/v2/internal/testgen/go122",
-	"raw":       "internal/trace/v2/raw",
-	"testtrace": "internal/trace/v2/testtrace",
-	//"version":          "internal/trace/v2/version",
-	"txtar": "internal/txtar",
-	//"errors":           "internal/types/errors",
-	"unsafeheader": "internal/unsafeheader",
-	"xcoff":        "internal/xcoff",
-	"zstd":         "internal/zstd",
-	"io":           "io",
-	"fs":           "io/fs",
-	"ioutil":       "io/ioutil",
-	"log":          "log",
-	"internal":     "log/internal",
-	"slog":         "log/slog",
-	//"internal":         "log/slog/internal",
-	"benchmarks": "log/slog/internal/benchmarks",
-	"buffer":     "log/slog/internal/buffer",
-	"slogtest":   "log/slog/internal/slogtest",
-	"syslog":     "log/syslog",
-	"maps":       "maps",
-	"math":       "math",
-	"big":        "math/big",
-	"bits":       "math/bits",
-	"cmplx":      "math/cmplx",
-	//"rand":             "math/rand",
-	//"v2":               "math/rand/v2",
+	"script":      "github.com/bitfield/script",
+	"tar":         "archive/tar",
+	"zip":         "archive/zip",
+	"bufio":       "bufio",
+	"bytes":       "bytes",
+	"cmp":         "cmp",
+	"bzip2":       "compress/bzip2",
+	"flate":       "compress/flate",
+	"gzip":        "compress/gzip",
+	"lzw":         "compress/lzw",
+	"zlib":        "compress/zlib",
+	"heap":        "container/heap",
+	"list":        "container/list",
+	"ring":        "container/ring",
+	"context":     "context",
+	"crypto":      "crypto",
+	"aes":         "crypto/aes",
+	"cipher":      "crypto/cipher",
+	"des":         "crypto/des",
+	"dsa":         "crypto/dsa",
+	"ecdh":        "crypto/ecdh",
+	"ecdsa":       "crypto/ecdsa",
+	"ed25519":     "crypto/ed25519",
+	"elliptic":    "crypto/elliptic",
+	"hmac":        "crypto/hmac",
+	"md5":         "crypto/md5",
+	"rand":        "crypto/rand",
+	"rc4":         "crypto/rc4",
+	"rsa":         "crypto/rsa",
+	"sha1":        "crypto/sha1",
+	"sha256":      "crypto/sha256",
+	"sha512":      "crypto/sha512",
+	"subtle":      "crypto/subtle",
+	"tls":         "crypto/tls",
+	"x509":        "crypto/x509",
+	"pkix":        "crypto/x509/pkix",
+	"sql":         "database/sql",
+	"driver":      "database/sql/driver",
+	"buildinfo":   "debug/buildinfo",
+	"dwarf":       "debug/dwarf",
+	"elf":         "debug/elf",
+	"gosym":       "debug/gosym",
+	"macho":       "debug/macho",
+	"pe":          "debug/pe",
+	"plan9obj":    "debug/plan9obj",
+	"embed":       "embed",
+	"encoding":    "encoding",
+	"ascii85":     "encoding/ascii85",
+	"asn1":        "encoding/asn1",
+	"base32":      "encoding/base32",
+	"base64":      "encoding/base64",
+	"binary":      "encoding/binary",
+	"csv":         "encoding/csv",
+	"gob":         "encoding/gob",
+	"hex":         "encoding/hex",
+	"json":        "encoding/json",
+	"pem":         "encoding/pem",
+	"xml":         "encoding/xml",
+	"errors":      "errors",
+	"expvar":      "expvar",
+	"flag":        "flag",
+	"fmt":         "fmt",
+	"ast":         "go/ast",
+	"build":       "go/build",
+	"constraint":  "go/build/constraint",
+	"constant":    "go/constant",
+	"doc":         "go/doc",
+	"comment":     "go/doc/comment",
+	"format":      "go/format",
+	"importer":    "go/importer",
+	"parser":      "go/parser",
+	"printer":     "go/printer",
+	"scanner":     "go/scanner",
+	"token":       "go/token",
+	"types":       "go/types",
+	"version":     "go/version",
+	"hash":        "hash",
+	"adler32":     "hash/adler32",
+	"crc32":       "hash/crc32",
+	"crc64":       "hash/crc64",
+	"fnv":         "hash/fnv",
+	"maphash":     "hash/maphash",
+	"html":        "html",
+	"template":    "html/template",
+	"image":       "image",
+	"color":       "image/color",
+	"palette":     "image/color/palette",
+	"draw":        "image/draw",
+	"gif":         "image/gif",
+	"jpeg":        "image/jpeg",
+	"png":         "image/png",
+	"suffixarray": "index/suffixarray",
+	"io":          "io",
+	"fs":          "io/fs",
+	"ioutil":      "io/ioutil",
+	"log":         "log",
+	"slog":        "log/slog",
+	"syslog":      "log/syslog",
+	"maps":        "maps",
+	"math":        "math",
+	"big":         "math/big",
+	"bits":        "math/bits",
+	"cmplx":       "math/cmplx",
+	//"rand":            "math/rand",
+	//"v2":              "math/rand/v2",
 	"mime":            "mime",
 	"multipart":       "mime/multipart",
 	"quotedprintable": "mime/quotedprintable",
@@ -210,85 +117,61 @@ var ImportsMap = map[string]string{
 	"httptest":        "net/http/httptest",
 	"httptrace":       "net/http/httptrace",
 	"httputil":        "net/http/httputil",
-	//"internal":         "net/http/internal",
-	"ascii":     "net/http/internal/ascii",
-	"testcert":  "net/http/internal/testcert",
-	"pprof":     "net/http/pprof",
-	"socktest":  "net/internal/socktest",
-	"mail":      "net/mail",
-	"netip":     "net/netip",
-	"rpc":       "net/rpc",
-	"jsonrpc":   "net/rpc/jsonrpc",
-	"smtp":      "net/smtp",
-	"textproto": "net/textproto",
-	"url":       "net/url",
-	"os":        "os",
-	"exec":      "os/exec",
-	"fdtest":    "os/exec/internal/fdtest",
-	"signal":    "os/signal",
-	"user":      "os/user",
-	"path":      "path",
-	"filepath":  "path/filepath",
-	"plugin":    "plugin",
-	"reflect":   "reflect",
-	"example1":  "reflect/internal/example1",
-	"example2":  "reflect/internal/example2",
-	"re":        "regexp",
-	"syntax":    "regexp/syntax",
-	"runtime":   "runtime",
-	"cgo":       "runtime/cgo",
-	//"coverage":         "runtime/coverage",
-	"debug":  "runtime/debug",
-	"atomic": "runtime/internal/atomic",
-	//"math":             "runtime/internal/math",
-	"startlinetest": "runtime/internal/startlinetest",
-	"sys":           "runtime/internal/sys",
-	"syscall":       "runtime/internal/syscall",
-	"wasitest":      "runtime/internal/wasitest",
-	"metrics":       "runtime/metrics",
-	//"pprof":            "runtime/pprof",
-	//"race":             "runtime/race",
-	"amd64v1": "runtime/race/internal/amd64v1",
-	//"trace":            "runtime/trace",
-	"slices":  "slices",
-	"sort":    "sort",
-	"strconv": "strconv",
-	"strings": "strings",
-	"sync":    "sync",
-	//"atomic":           "sync/atomic",
-	//"syscall":          "syscall",
+	"pprof":           "net/http/pprof",
+	"mail":            "net/mail",
+	"netip":           "net/netip",
+	"rpc":             "net/rpc",
+	"jsonrpc":         "net/rpc/jsonrpc",
+	"smtp":            "net/smtp",
+	"textproto":       "net/textproto",
+	"url":             "net/url",
+	"os":              "os",
+	"exec":            "os/exec",
+	"signal":          "os/signal",
+	"user":            "os/user",
+	"path":            "path",
+	"filepath":        "path/filepath",
+	"plugin":          "plugin",
+	"reflect":         "reflect",
+	"re":              "regexp",
+	"syntax":          "regexp/syntax",
+	"runtime":         "runtime",
+	"cgo":             "runtime/cgo",
+	"coverage":        "runtime/coverage",
+	"debug":           "runtime/debug",
+	"metrics":         "runtime/metrics",
+	//"pprof":           "runtime/pprof",
+	"race":     "runtime/race",
+	"trace":    "runtime/trace",
+	"slices":   "slices",
+	"sort":     "sort",
+	"strconv":  "strconv",
+	"strings":  "strings",
+	"sync":     "sync",
+	"atomic":   "sync/atomic",
+	"syscall":  "syscall",
 	"testing":  "testing",
 	"fstest":   "testing/fstest",
-	"testdeps": "testing/internal/testdeps",
 	"iotest":   "testing/iotest",
 	"quick":    "testing/quick",
-	//"slogtest":         "testing/slogtest",
-	//"scanner":          "text/scanner",
-	"tabwriter":        "text/tabwriter",
-	"txttmpl":          "text/template",
-	"parse":            "text/template/parse",
-	"time":             "time",
-	"tzdata":           "time/tzdata",
-	"unicode":          "unicode",
-	"utf16":            "unicode/utf16",
-	"utf8":             "unicode/utf8",
-	"unsafe":           "unsafe",
-	"chacha20":         "vendor/golang.org/x/crypto/chacha20",
-	"chacha20poly1305": "vendor/golang.org/x/crypto/chacha20poly1305",
-	"cryptobyte":       "vendor/golang.org/x/crypto/cryptobyte",
-	//"asn1":             "vendor/golang.org/x/crypto/cryptobyte/asn1",
-	"hkdf": "vendor/golang.org/x/crypto/hkdf",
-	//"alias":            "vendor/golang.org/x/crypto/internal/alias",
-	"poly1305":   "vendor/golang.org/x/crypto/internal/poly1305",
-	"dnsmessage": "vendor/golang.org/x/net/dns/dnsmessage",
-	"httpguts":   "vendor/golang.org/x/net/http/httpguts",
-	"httpproxy":  "vendor/golang.org/x/net/http/httpproxy",
-	"hpack":      "vendor/golang.org/x/net/http2/hpack",
-	"idna":       "vendor/golang.org/x/net/idna",
-	"nettest":    "vendor/golang.org/x/net/nettest",
-	//"cpu":              "vendor/golang.org/x/sys/cpu",
-	"bidirule":  "vendor/golang.org/x/text/secure/bidirule",
-	"transform": "vendor/golang.org/x/text/transform",
-	"bidi":      "vendor/golang.org/x/text/unicode/bidi",
-	"norm":      "vendor/golang.org/x/text/unicode/norm",
+	"slogtest": "testing/slogtest",
+	//"scanner":         "text/scanner",
+	"tabwriter": "text/tabwriter",
+	"txttmpl":   "text/template",
+	"parse":     "text/template/parse",
+	"time":      "time",
+	"tzdata":    "time/tzdata",
+	"unicode":   "unicode",
+	"utf16":     "unicode/utf16",
+	"utf8":      "unicode/utf8",
+	"unsafe":    "unsafe",
+}
+
+// Package names shared by more than one standard library package. ImportsMap holds the default
+// for each. The resolver checks which candidate exports the symbols a snippet uses.
+var CollidingImports = map[string][]string{
+	"pprof":    {"net/http/pprof", "runtime/pprof"},
+	"rand":     {"crypto/rand", "math/rand", "math/rand/v2"},
+	"scanner":  {"go/scanner", "text/scanner"},
+	"template": {"html/template", "text/template"},
 }